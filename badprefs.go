@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/vfaronov/gunison/prf"
+)
+
+// badPrefs are Unison preferences that change its console behavior in ways Gunison can't handle.
+// Gunison overrides them on the command line, which takes precedence over the profile.
+var badPrefs = []struct {
+	name     string
+	isBool   bool
+	harmless func(value string) bool
+	override string
+}{
+	{"auto", true, isFalse, "false"},     // Unison would not prompt for most items
+	{"batch", true, isFalse, "false"},    // Unison would not prompt at all
+	{"silent", true, isFalse, "false"},   // implies batch
+	{"terse", true, isFalse, "false"},    // Unison would not report what's going on
+	{"color", false, isNotTrue, "false"}, // Unison would intersperse escape sequences
+	{"repeat", false, isEmpty, ""},       // Unison would never exit
+	{"ui", false, isText, "text"},        // Unison would start its own GUI
+}
+
+func isFalse(v string) bool   { return v == "false" || v == "no" }
+func isNotTrue(v string) bool { return v != "true" && v != "yes" }
+func isEmpty(v string) bool   { return v == "" }
+func isText(v string) bool    { return v == "text" }
+
+// NeutralizePrefs returns args (command-line arguments for Unison) with overrides appended
+// for those badPrefs that are set in args or profile (which may be nil), along with the names
+// of the overridden preferences. It does not modify args.
+func NeutralizePrefs(args []string, profile *prf.File) ([]string, []string) {
+	values := map[string]string{}
+	if profile != nil {
+		for _, bad := range badPrefs {
+			if v, ok := profile.Value(bad.name); ok {
+				values[bad.name] = v
+			}
+		}
+	}
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimPrefix(args[i], "-")
+		if name == args[i] {
+			continue
+		}
+		value, hasValue := "", false
+		if j := strings.IndexByte(name, '='); j != -1 {
+			name, value, hasValue = name[:j], name[j+1:], true
+		}
+		for _, bad := range badPrefs {
+			if name != bad.name {
+				continue
+			}
+			switch {
+			case hasValue:
+			case bad.isBool:
+				value = "true"
+			case i+1 < len(args):
+				i++
+				value = args[i]
+			}
+			values[name] = value
+		}
+	}
+
+	var overrides, overridden []string
+	for _, bad := range badPrefs {
+		if v, ok := values[bad.name]; ok && !bad.harmless(strings.TrimSpace(v)) {
+			overrides = append(overrides, "-"+bad.name+"="+bad.override)
+			overridden = append(overridden, bad.name)
+		}
+	}
+	return append(args[:len(args):len(args)], overrides...), overridden
+}
+
+// PrefIsSet reports whether the preference name is set in args (command-line arguments
+// for Unison) or profile (which may be nil).
+func PrefIsSet(args []string, profile *prf.File, name string) bool {
+	if profile != nil {
+		if _, ok := profile.Value(name); ok {
+			return true
+		}
+	}
+	for _, arg := range args {
+		if arg == "-"+name || strings.HasPrefix(arg, "-"+name+"=") {
+			return true
+		}
+	}
+	return false
+}
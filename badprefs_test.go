@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vfaronov/gunison/prf"
+)
+
+func TestNeutralizePrefs(t *testing.T) {
+	cases := []struct {
+		name       string
+		args       []string
+		profile    string
+		expected   []string
+		overridden []string
+	}{
+		{
+			name:     lineno(),
+			args:     []string{"work"},
+			profile:  "root = /a\nroot = /b\n",
+			expected: []string{"work"},
+		},
+		{
+			name:       lineno(),
+			args:       []string{"work"},
+			profile:    "root = /a\nroot = /b\nterse = true\nrepeat = watch\nauto = false\n",
+			expected:   []string{"work", "-terse=false", "-repeat="},
+			overridden: []string{"terse", "repeat"},
+		},
+		{
+			name:     lineno(),
+			args:     []string{"work", "-terse=false", "-repeat", ""},
+			profile:  "terse = true\nrepeat = 30\n",
+			expected: []string{"work", "-terse=false", "-repeat", ""},
+		},
+		{
+			name:       lineno(),
+			args:       []string{"/a", "/b", "-batch", "-repeat", "watch", "-ui", "graphic", "-times"},
+			expected:   []string{"/a", "/b", "-batch", "-repeat", "watch", "-ui", "graphic", "-times", "-batch=false", "-repeat=", "-ui=text"},
+			overridden: []string{"batch", "repeat", "ui"},
+		},
+		{
+			name:     lineno(),
+			args:     []string{"-auto", "work"},
+			profile:  "auto = false\nui = text\ncolor = default\n",
+			expected: []string{"-auto", "work", "-auto=false"},
+			// The command line takes precedence.
+			overridden: []string{"auto"},
+		},
+		{
+			name:       lineno(),
+			args:       []string{"work", "-silent=true", "-color=true", "-autobatch"},
+			expected:   []string{"work", "-silent=true", "-color=true", "-autobatch", "-silent=false", "-color=false"},
+			overridden: []string{"silent", "color"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var profile *prf.File
+			if c.profile != "" {
+				var err error
+				profile, err = prf.Parse(strings.NewReader(c.profile))
+				require.NoError(t, err)
+			}
+			args := append([]string(nil), c.args...)
+			actual, overridden := NeutralizePrefs(args, profile)
+			assertEqual(t, actual, c.expected)
+			assertEqual(t, overridden, c.overridden)
+			assertEqual(t, args, c.args)
+		})
+	}
+}
+
+func TestPrefIsSet(t *testing.T) {
+	profile, err := prf.Parse(strings.NewReader("root = /a\nroot = /b\n#diff = meld CURRENT1 CURRENT2\n"))
+	require.NoError(t, err)
+	assert.False(t, PrefIsSet([]string{"work", "-differences"}, profile, "diff"))
+	assert.True(t, PrefIsSet([]string{"work", "-diff", "meld CURRENT1 CURRENT2"}, profile, "diff"))
+	assert.True(t, PrefIsSet([]string{"work", "-diff=kdiff3 CURRENT1 CURRENT2"}, nil, "diff"))
+	profile.Set("diff", "meld CURRENT1 CURRENT2")
+	assert.True(t, PrefIsSet([]string{"work"}, profile, "diff"))
+}
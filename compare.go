@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Comparison is a side-by-side view of the differences between two versions of a text file.
+type Comparison struct {
+	Rows []Row
+}
+
+// A Row is one line of a Comparison, showing a line of either version or of both.
+type Row struct {
+	Kind        RowKind
+	Left, Right string // without line terminators; empty if absent on that side
+	// For RowChanged, byte ranges within Left and Right that differ from the other side.
+	LeftSpans, RightSpans []Span
+}
+
+// A RowKind says how the two sides of a Row relate to each other.
+type RowKind byte
+
+const (
+	RowSame    RowKind = iota // the line is the same in both versions
+	RowChanged                // the line on the left has become the line on the right
+	RowRemoved                // the line is only on the left
+	RowAdded                  // the line is only on the right
+)
+
+// A Span is a range of bytes in a string, from Start inclusive to End exclusive.
+type Span struct {
+	Start, End int
+}
+
+const (
+	// Beyond these limits, a line-by-line comparison is too slow to be worth it.
+	maxCompareSize  = 4 << 20
+	maxCompareEdits = 2000
+	// Beyond this, intra-line differences are not highlighted; the whole line is.
+	maxSpanLineLen = 1000
+)
+
+// LocalPath returns the file name of the item with the given Path in the replica at root,
+// or false if root is not on this machine.
+func LocalPath(root Root, path string) (string, bool) {
+	hostname := os.Getenv("UNISONLOCALHOSTNAME")
+	if hostname == "" {
+		var err error
+		if hostname, err = os.Hostname(); err != nil {
+			return "", false
+		}
+	}
+	if root.Path == "" || !strings.EqualFold(root.Host, hostname) {
+		return "", false
+	}
+	return filepath.Join(filepath.FromSlash(root.Path), filepath.FromSlash(path)), true
+}
+
+// IsText reports whether data looks like text that can be compared line by line.
+func IsText(data []byte) bool {
+	const sniffLen = 8000 // same as in Git
+	if len(data) > sniffLen {
+		data = data[:sniffLen]
+	}
+	return bytes.IndexByte(data, 0) == -1
+}
+
+// Compare returns a Comparison of the left and right versions of a text file, or false if they
+// are too large or too different to compare. Invalid UTF-8 is replaced with U+FFFD.
+func Compare(left, right []byte) (*Comparison, bool) {
+	if len(left) > maxCompareSize || len(right) > maxCompareSize {
+		return nil, false
+	}
+	a, b := splitLines(left), splitLines(right)
+	pairs, ok := commonPairs(len(a), len(b), maxCompareEdits, func(i, j int) bool { return a[i] == b[j] })
+	if !ok {
+		return nil, false
+	}
+
+	cmp := &Comparison{}
+	i, j := 0, 0
+	for _, pair := range append(pairs, [2]int{len(a), len(b)}) {
+		for ; i < pair[0] && j < pair[1]; i, j = i+1, j+1 {
+			leftSpans, rightSpans := diffSpans(a[i], b[j])
+			cmp.Rows = append(cmp.Rows, Row{
+				Kind:       RowChanged,
+				Left:       a[i],
+				Right:      b[j],
+				LeftSpans:  leftSpans,
+				RightSpans: rightSpans,
+			})
+		}
+		for ; i < pair[0]; i++ {
+			cmp.Rows = append(cmp.Rows, Row{Kind: RowRemoved, Left: a[i]})
+		}
+		for ; j < pair[1]; j++ {
+			cmp.Rows = append(cmp.Rows, Row{Kind: RowAdded, Right: b[j]})
+		}
+		if i < len(a) && j < len(b) {
+			cmp.Rows = append(cmp.Rows, Row{Kind: RowSame, Left: a[i], Right: b[j]})
+			i, j = i+1, j+1
+		}
+	}
+	return cmp, true
+}
+
+// Changes returns the indices of Rows that begin a run of differing lines.
+func (cmp *Comparison) Changes() []int {
+	var changes []int
+	for i, row := range cmp.Rows {
+		if row.Kind != RowSame && (i == 0 || cmp.Rows[i-1].Kind == RowSame) {
+			changes = append(changes, i)
+		}
+	}
+	return changes
+}
+
+// Unified returns the differences in the unified format (as in diff -u), with 3 lines of context
+// and the given names for the two versions. Like diff, it returns nothing if there are no differences.
+func (cmp *Comparison) Unified(leftName, rightName string) string {
+	const context = 3
+	changes := cmp.Changes()
+	if len(changes) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", leftName, rightName)
+	// Line numbers (starting from 0) on either side for the beginning of each row.
+	leftLine, rightLine := make([]int, len(cmp.Rows)+1), make([]int, len(cmp.Rows)+1)
+	for i, row := range cmp.Rows {
+		leftLine[i+1], rightLine[i+1] = leftLine[i], rightLine[i]
+		if row.Kind != RowAdded {
+			leftLine[i+1]++
+		}
+		if row.Kind != RowRemoved {
+			rightLine[i+1]++
+		}
+	}
+
+	for k := 0; k < len(changes); {
+		// Extend the hunk over all changes that are close enough for their contexts to overlap.
+		begin := changes[k] - context
+		if begin < 0 {
+			begin = 0
+		}
+		end := cmp.changeEnd(changes[k])
+		for k++; k < len(changes) && changes[k]-end <= 2*context; k++ {
+			end = cmp.changeEnd(changes[k])
+		}
+		end += context
+		if end > len(cmp.Rows) {
+			end = len(cmp.Rows)
+		}
+
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n",
+			hunkRange(leftLine[begin], leftLine[end]), hunkRange(rightLine[begin], rightLine[end]))
+		for i := begin; i < end; {
+			if cmp.Rows[i].Kind == RowSame {
+				sb.WriteString(" " + cmp.Rows[i].Left + "\n")
+				i++
+				continue
+			}
+			changeEnd := cmp.changeEnd(i)
+			for _, row := range cmp.Rows[i:changeEnd] {
+				if row.Kind != RowAdded {
+					sb.WriteString("-" + row.Left + "\n")
+				}
+			}
+			for _, row := range cmp.Rows[i:changeEnd] {
+				if row.Kind != RowRemoved {
+					sb.WriteString("+" + row.Right + "\n")
+				}
+			}
+			i = changeEnd
+		}
+	}
+	return sb.String()
+}
+
+// changeEnd returns the index of the first RowSame at or after i, or len(cmp.Rows).
+func (cmp *Comparison) changeEnd(i int) int {
+	for i < len(cmp.Rows) && cmp.Rows[i].Kind != RowSame {
+		i++
+	}
+	return i
+}
+
+// hunkRange formats the range of lines from begin (inclusive, from 0) to end (exclusive)
+// for a unified hunk header.
+func hunkRange(begin, end int) string {
+	switch {
+	case end-begin == 1:
+		return fmt.Sprint(begin + 1)
+	case end == begin: // an empty range is denoted by the line before it
+		return fmt.Sprintf("%d,0", begin)
+	default:
+		return fmt.Sprintf("%d,%d", begin+1, end-begin)
+	}
+}
+
+func splitLines(data []byte) []string {
+	s := strings.ToValidUTF8(string(data), "\uFFFD")
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\r")
+	}
+	return lines
+}
+
+// diffSpans returns the byte ranges of a and b that are not common to both.
+func diffSpans(a, b string) ([]Span, []Span) {
+	if len(a) > maxSpanLineLen || len(b) > maxSpanLineLen {
+		return []Span{{0, len(a)}}, []Span{{0, len(b)}}
+	}
+	ra, rb := []rune(a), []rune(b)
+	pairs, ok := commonPairs(len(ra), len(rb), maxSpanLineLen, func(i, j int) bool { return ra[i] == rb[j] })
+	if !ok {
+		return []Span{{0, len(a)}}, []Span{{0, len(b)}}
+	}
+	common := make([]int, 0, len(pairs))
+	for _, pair := range pairs {
+		common = append(common, pair[0])
+	}
+	aSpans := uncommonSpans(ra, common)
+	common = common[:0]
+	for _, pair := range pairs {
+		common = append(common, pair[1])
+	}
+	return aSpans, uncommonSpans(rb, common)
+}
+
+// uncommonSpans returns the byte ranges covering the runes of rs whose indices are not in common
+// (which must be ascending).
+func uncommonSpans(rs []rune, common []int) []Span {
+	var spans []Span
+	offset, k := 0, 0
+	for i, r := range rs {
+		size := utf8.RuneLen(r)
+		if k < len(common) && common[k] == i {
+			k++
+		} else if n := len(spans); n > 0 && spans[n-1].End == offset {
+			spans[n-1].End += size
+		} else {
+			spans = append(spans, Span{offset, offset + size})
+		}
+		offset += size
+	}
+	return spans
+}
+
+// commonPairs finds a longest common subsequence of two sequences of lengths n and m, whose
+// elements are compared with eq. It returns the pairs of indices of elements in the subsequence,
+// or false if the sequences differ by more than maxEdits insertions and deletions.
+// This is the algorithm by Eugene W. Myers, "An O(ND) Difference Algorithm and Its Variations".
+func commonPairs(n, m, maxEdits int, eq func(i, j int) bool) ([][2]int, bool) {
+	// Common prefix and suffix are found trivially and make the rest faster.
+	prefix := 0
+	for prefix < n && prefix < m && eq(prefix, prefix) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < n-prefix && suffix < m-prefix && eq(n-1-suffix, m-1-suffix) {
+		suffix++
+	}
+	pairs := make([][2]int, 0, prefix+suffix)
+	for i := 0; i < prefix; i++ {
+		pairs = append(pairs, [2]int{i, i})
+	}
+	middle, ok := myers(n-prefix-suffix, m-prefix-suffix, maxEdits, func(i, j int) bool {
+		return eq(prefix+i, prefix+j)
+	})
+	if !ok {
+		return nil, false
+	}
+	for _, pair := range middle {
+		pairs = append(pairs, [2]int{prefix + pair[0], prefix + pair[1]})
+	}
+	for i := suffix; i > 0; i-- {
+		pairs = append(pairs, [2]int{n - i, m - i})
+	}
+	return pairs, true
+}
+
+func myers(n, m, maxEdits int, eq func(i, j int) bool) ([][2]int, bool) {
+	// v[offset+k] is the furthest x reached on diagonal k = x - y.
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	// trace[d] is v as it was before step d, for diagonals -d-1 through d+1.
+	var trace [][]int
+	found := false
+	for d := 0; d <= n+m && !found; d++ {
+		if d > maxEdits {
+			return nil, false
+		}
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // down: insertion
+			} else {
+				x = v[offset+k-1] + 1 // right: deletion
+			}
+			y := x - k
+			for x < n && y < m && eq(x, y) {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	// Walk back from the end, collecting the diagonal moves (snakes).
+	var pairs [][2]int
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+		var prevK, snakeX int
+		if k == -d || (k != d && prev(k-1) < prev(k+1)) {
+			prevK = k + 1
+			snakeX = prev(prevK)
+		} else {
+			prevK = k - 1
+			snakeX = prev(prevK) + 1
+		}
+		for x > snakeX {
+			x, y = x-1, y-1
+			pairs = append(pairs, [2]int{x, y})
+		}
+		x = prev(prevK)
+		y = x - prevK
+	}
+	for x > 0 && y > 0 {
+		x, y = x-1, y-1
+		pairs = append(pairs, [2]int{x, y})
+	}
+
+	for i, j := 0, len(pairs)-1; i < j; i, j = i+1, j-1 {
+		pairs[i], pairs[j] = pairs[j], pairs[i]
+	}
+	return pairs, true
+}
+
+// A HexComparison is a Comparison of hex dumps of two versions of a binary file. Unlike in text,
+// bytes are compared at the same offsets, because insertions are rare and costly to find.
+type HexComparison struct {
+	Comparison
+	Offset int  // of the first byte that differs, or -1 if none
+	Row    int  // index into Rows of the line with that byte, or -1
+	Span   Span // of that byte's hex digits within the line, on both sides
+}
+
+const (
+	hexLineLen    = 16       // bytes per line of a hex dump
+	maxHexDumpLen = 64 << 10 // a hex dump shows no more than this from each version
+	hexContext    = 8 * hexLineLen
+)
+
+// HexDump returns a HexComparison of the left and right versions of a binary file. Only a part
+// of a large file is dumped, starting a few lines before the first difference.
+func HexDump(left, right []byte) *HexComparison {
+	hc := &HexComparison{Offset: -1, Row: -1}
+	n := len(left)
+	if len(right) < n {
+		n = len(right)
+	}
+	for i := 0; i < n; i++ {
+		if left[i] != right[i] {
+			hc.Offset = i
+			break
+		}
+	}
+	if hc.Offset == -1 && len(left) != len(right) {
+		hc.Offset = n
+	}
+
+	begin := 0
+	if hc.Offset > hexContext {
+		begin = (hc.Offset - hexContext) / hexLineLen * hexLineLen
+	}
+	for off := begin; off < begin+maxHexDumpLen && (off < len(left) || off < len(right)); off += hexLineLen {
+		a, b := hexLineAt(left, off), hexLineAt(right, off)
+		row := Row{Kind: RowSame}
+		switch {
+		case a == nil:
+			row.Kind = RowAdded
+			row.Right, _ = hexLine(off, b, nil)
+		case b == nil:
+			row.Kind = RowRemoved
+			row.Left, _ = hexLine(off, a, nil)
+		case bytes.Equal(a, b):
+			row.Left, _ = hexLine(off, a, b)
+			row.Right = row.Left
+		default:
+			row.Kind = RowChanged
+			row.Left, row.LeftSpans = hexLine(off, a, b)
+			row.Right, row.RightSpans = hexLine(off, b, a)
+		}
+		if hc.Offset >= off && hc.Offset < off+hexLineLen {
+			hc.Row = len(hc.Rows)
+			col := hexColumn(hc.Offset - off)
+			hc.Span = Span{col, col + 2}
+		}
+		hc.Rows = append(hc.Rows, row)
+	}
+	return hc
+}
+
+// hexLineAt returns the bytes of data to be dumped in one line starting at off, or nil if none.
+func hexLineAt(data []byte, off int) []byte {
+	if off >= len(data) {
+		return nil
+	}
+	if end := off + hexLineLen; end < len(data) {
+		return data[off:end]
+	}
+	return data[off:]
+}
+
+// hexLine formats one line of a hex dump, in the style of hexdump -C, of data starting at off.
+// It also returns the ranges of the line that show bytes of data differing from other.
+func hexLine(off int, data, other []byte) (string, []Span) {
+	var sb strings.Builder
+	var hexSpans, textSpans []Span
+	fmt.Fprintf(&sb, "%08x ", off)
+	for i := 0; i < hexLineLen; i++ {
+		if i%8 == 0 {
+			sb.WriteByte(' ')
+		}
+		if i >= len(data) {
+			sb.WriteString("   ")
+			continue
+		}
+		fmt.Fprintf(&sb, "%02x ", data[i])
+		if i >= len(other) || data[i] != other[i] {
+			col := hexColumn(i)
+			if n := len(hexSpans); n > 0 && hexSpans[n-1].End+1 == col {
+				hexSpans[n-1].End = col + 2 // join adjacent bytes, but not across the middle gap
+			} else {
+				hexSpans = append(hexSpans, Span{col, col + 2})
+			}
+		}
+	}
+	sb.WriteString(" |")
+	for i, c := range data {
+		if c < ' ' || c > '~' {
+			c = '.'
+		}
+		if i >= len(other) || data[i] != other[i] {
+			col := sb.Len()
+			if n := len(textSpans); n > 0 && textSpans[n-1].End == col {
+				textSpans[n-1].End++
+			} else {
+				textSpans = append(textSpans, Span{col, col + 1})
+			}
+		}
+		sb.WriteByte(c)
+	}
+	sb.WriteByte('|')
+	return sb.String(), append(hexSpans, textSpans...)
+}
+
+// hexColumn returns the position, within a line of a hex dump, of the i-th byte's hex digits.
+func hexColumn(i int) int {
+	col := 10 + 3*i
+	if i >= 8 {
+		col++
+	}
+	return col
+}
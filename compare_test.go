@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"pgregory.net/rapid"
+)
+
+func TestCompare(t *testing.T) {
+	cases := []struct {
+		name        string
+		left, right string
+		expected    []Row
+	}{
+		{
+			name:     lineno(),
+			left:     "",
+			right:    "",
+			expected: nil,
+		},
+		{
+			name:  lineno(),
+			left:  "one\ntwo\nthree\n",
+			right: "one\nzwei\nthree\n",
+			expected: []Row{
+				{Kind: RowSame, Left: "one", Right: "one"},
+				{Kind: RowChanged, Left: "two", Right: "zwei", LeftSpans: []Span{{0, 1}, {2, 3}}, RightSpans: []Span{{0, 1}, {2, 4}}},
+				{Kind: RowSame, Left: "three", Right: "three"},
+			},
+		},
+		{
+			name:  lineno(),
+			left:  "one\ntwo\nthree\nfour\n",
+			right: "zero\none\nthree\nfour",
+			expected: []Row{
+				{Kind: RowAdded, Right: "zero"},
+				{Kind: RowSame, Left: "one", Right: "one"},
+				{Kind: RowRemoved, Left: "two"},
+				{Kind: RowSame, Left: "three", Right: "three"},
+				{Kind: RowSame, Left: "four", Right: "four"},
+			},
+		},
+		{
+			name:  lineno(),
+			left:  "a\r\nb\r\n",
+			right: "a\nB\nc\n",
+			expected: []Row{
+				{Kind: RowSame, Left: "a", Right: "a"},
+				{Kind: RowChanged, Left: "b", Right: "B", LeftSpans: []Span{{0, 1}}, RightSpans: []Span{{0, 1}}},
+				{Kind: RowAdded, Right: "c"},
+			},
+		},
+		{
+			name:  lineno(),
+			left:  "привет, мир\n",
+			right: "привет, world\n",
+			expected: []Row{
+				{Kind: RowChanged, Left: "привет, мир", Right: "привет, world",
+					LeftSpans: []Span{{14, 20}}, RightSpans: []Span{{14, 19}}},
+			},
+		},
+		{
+			name:  lineno(),
+			left:  "caf\xe9\n",
+			right: "café\n",
+			expected: []Row{
+				{Kind: RowChanged, Left: "caf�", Right: "café", LeftSpans: []Span{{3, 6}}, RightSpans: []Span{{3, 5}}},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cmp, ok := Compare([]byte(c.left), []byte(c.right))
+			require.True(t, ok)
+			assertEqual(t, cmp.Rows, c.expected)
+		})
+	}
+}
+
+func TestCompareTooDifferent(t *testing.T) {
+	var left, right []byte
+	for i := 0; i < maxCompareEdits; i++ {
+		left = append(left, "left\n"...)
+		right = append(right, "right\n"...)
+	}
+	_, ok := Compare(left, right)
+	assert.False(t, ok)
+}
+
+func TestUnified(t *testing.T) {
+	cmp, ok := Compare(
+		[]byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n17\n18\n19\n20\n"),
+		[]byte("0\n1\n2\n3\n4\n5\nsix\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n18\n19\n20\n"))
+	require.True(t, ok)
+	assertEqual(t, cmp.Changes(), []int{0, 6, 17})
+	assertEqual(t, cmp.Unified("left/foo.txt", "right/foo.txt"), `--- left/foo.txt
++++ right/foo.txt
+@@ -1,9 +1,10 @@
++0
+ 1
+ 2
+ 3
+ 4
+ 5
+-6
++six
+ 7
+ 8
+ 9
+@@ -14,7 +15,6 @@
+ 14
+ 15
+ 16
+-17
+ 18
+ 19
+ 20
+`)
+}
+
+func TestUnifiedSame(t *testing.T) {
+	cmp, ok := Compare([]byte("one\ntwo\n"), []byte("one\ntwo\n"))
+	require.True(t, ok)
+	assertEqual(t, cmp.Unified("left/foo.txt", "right/foo.txt"), "")
+}
+
+func TestCommonPairs(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		a := rapid.SliceOf(rapid.IntRange(0, 3)).Draw(t, "a").([]int)
+		b := rapid.SliceOf(rapid.IntRange(0, 3)).Draw(t, "b").([]int)
+		pairs, ok := commonPairs(len(a), len(b), len(a)+len(b), func(i, j int) bool { return a[i] == b[j] })
+		if !ok {
+			t.Fatalf("failed to compare")
+		}
+		for k, pair := range pairs {
+			if a[pair[0]] != b[pair[1]] {
+				t.Fatalf("pair %v is not common", pair)
+			}
+			if k > 0 && (pair[0] <= pairs[k-1][0] || pair[1] <= pairs[k-1][1]) {
+				t.Fatalf("pair %v is out of order", pair)
+			}
+		}
+		// Compare with the textbook dynamic programming solution.
+		lcs := make([][]int, len(a)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				switch {
+				case a[i] == b[j]:
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				case lcs[i+1][j] > lcs[i][j+1]:
+					lcs[i][j] = lcs[i+1][j]
+				default:
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		if len(pairs) != lcs[0][0] {
+			t.Fatalf("got %d common elements instead of %d", len(pairs), lcs[0][0])
+		}
+	})
+}
+
+func TestLocalPath(t *testing.T) {
+	t.Setenv("UNISONLOCALHOSTNAME", "aqtau")
+	name, ok := LocalPath(Root{"aqtau", "/home/vasiliy/docs"}, "work/todo.txt")
+	assert.True(t, ok)
+	assertEqual(t, name, filepath.FromSlash("/home/vasiliy/docs/work/todo.txt"))
+	_, ok = LocalPath(Root{"raspberrypi", "/home/vasiliy/docs"}, "work/todo.txt")
+	assert.False(t, ok)
+	_, ok = LocalPath(Root{}, "work/todo.txt")
+	assert.False(t, ok)
+}
+
+func TestHexDump(t *testing.T) {
+	hc := HexDump([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x10"), []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x01\x10\x00"))
+	assertEqual(t, hc.Rows, []Row{
+		{
+			Kind:  RowSame,
+			Left:  "00000000  89 50 4e 47 0d 0a 1a 0a  00 00 00 0d 49 48 44 52  |.PNG........IHDR|",
+			Right: "00000000  89 50 4e 47 0d 0a 1a 0a  00 00 00 0d 49 48 44 52  |.PNG........IHDR|",
+		},
+		{
+			Kind:       RowChanged,
+			Left:       "00000010  00 00 00 10                                       |....|",
+			Right:      "00000010  00 00 01 10 00                                    |.....|",
+			LeftSpans:  []Span{{16, 18}, {63, 64}},
+			RightSpans: []Span{{16, 18}, {22, 24}, {63, 64}, {65, 66}},
+		},
+	})
+	assertEqual(t, hc.Offset, 18)
+	assertEqual(t, hc.Row, 1)
+	assertEqual(t, hc.Span, Span{16, 18})
+}
+
+func TestHexDumpLarge(t *testing.T) {
+	left := make([]byte, 1<<20)
+	right := make([]byte, 1<<20)
+	right[500000] = 'x'
+	right[500001] = 'y'
+	right[500009] = 'z'
+	hc := HexDump(left, right)
+	assertEqual(t, hc.Offset, 500000)
+	assertEqual(t, len(hc.Rows), maxHexDumpLen/hexLineLen)
+	assertEqual(t, hc.Rows[0].Left[:8], fmt.Sprintf("%08x", 500000/16*16-hexContext))
+	row := hc.Rows[hc.Row]
+	assertEqual(t, row.Kind, RowChanged)
+	assertEqual(t, row.Right, "0007a120  78 79 00 00 00 00 00 00  00 7a 00 00 00 00 00 00  |xy.......z......|")
+	assertEqual(t, row.RightSpans, []Span{{10, 15}, {38, 40}, {61, 63}, {70, 71}})
+	assertEqual(t, hc.Changes(), []int{hc.Row})
+}
+
+func TestHexDumpSame(t *testing.T) {
+	hc := HexDump([]byte("\x00\x01"), []byte("\x00\x01"))
+	assertEqual(t, hc.Offset, -1)
+	assertEqual(t, hc.Row, -1)
+	assert.Empty(t, hc.Changes())
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -25,24 +26,40 @@ type Core struct {
 	ProgressFraction float64 // quantitative representation of Progress: 0 to 1, or -1 for "no estimate"
 
 	// These fields become non-zero once the corresponding information is parsed from Unison.
-	Left, Right string // names of replicas
-	Items       []Item // items to synchronize - updated by the UI to set the desired Action
+	Left, Right         string // names of replicas
+	LeftRoot, RightRoot Root   // where replicas are, as Unison reports them when connected
+	Items               []Item // items to synchronize - updated by the UI to set the desired Action
+	Rules               []Rule // applied to each new plan - set by the UI
+
+	// RetryPaths becomes non-nil when Unison has finished synchronization with errors. It lists
+	// the Paths of Items that failed, so that the user may try them again in a new Unison process.
+	RetryPaths []string
 
 	// These functions must be called when the user requests the corresponding action via the UI.
 	// Any of these fields may be nil, which means the action is impossible and must not be offered
 	// to the user.
-	Diff      func(string) Update // load differences for the item with the given Path
-	Sync      func() Update       // start synchronization according to the Action of each of Items
-	Quit      func() Update       // quit Unison gracefully
-	Abort     func() Update       // abort current operation - often (but not always) same as Interrupt
-	Interrupt func() Update       // interrupt the Unison process
-	Kill      func() Update       // kill the Unison process
+	Diff      func(...string) Update      // load differences for the items with the given Paths
+	Ignore    func(string, Ignore) Update // permanently ignore the item with the given Path or similar
+	Sync      func() Update               // start synchronization according to the Action of each of Items
+	Quit      func() Update               // quit Unison gracefully
+	Rescan    func() Update               // quit Unison and start it again, keeping the Override of Items
+	Abort     func() Update               // abort current operation - often (but not always) same as Interrupt
+	Interrupt func() Update               // interrupt the Unison process
+	Kill      func() Update               // kill the Unison process
 
 	buf        bytes.Buffer
 	procBuffer func() Update
 	exitCodes  map[int]string
 	procError  func(error) Update
 	seek       string
+	diffQueue  []string          // paths to diff after seek, in the order of prompts
+	diffBegun  bool              // whether some of the current path's diff has been output
+	diffInLine bool              // whether buf begins in the middle of a line of diff output
+	diffAny    bool              // whether some diff has been output since Core.Diff was called
+	restart    bool              // whether Unison is to be started again once it exits
+	overrides  []Item            // from before the restart, to be applied to the new Items
+	ignored    func(string) bool // whether Unison ignores a path due to rules added via Ignore
+	prompts    map[string]int    // position of each Path in Unison's prompts (the UI may reorder Items)
 }
 
 // Core is a kind of a state machine, but it doesn't have a discrete "state" field.
@@ -59,12 +76,27 @@ func (c *Core) transition(newc Core) Update {
 	if newc.Right == "" {
 		newc.Right = c.Right
 	}
+	if newc.LeftRoot == (Root{}) {
+		newc.LeftRoot = c.LeftRoot
+	}
+	if newc.RightRoot == (Root{}) {
+		newc.RightRoot = c.RightRoot
+	}
 	if newc.Items == nil {
 		newc.Items = c.Items
 	}
+	if newc.Rules == nil {
+		newc.Rules = c.Rules
+	}
 	if newc.exitCodes == nil {
 		newc.exitCodes = c.exitCodes
 	}
+	if newc.ignored == nil {
+		newc.ignored = c.ignored
+	}
+	if newc.prompts == nil {
+		newc.prompts = c.prompts
+	}
 	newc.buf = c.buf
 	*c = newc
 	return c.next()
@@ -74,10 +106,13 @@ func (c *Core) transition(newc Core) Update {
 // before calling any further Update-returning methods/functions on Core.
 type Update struct {
 	Progressed bool      // if true, the user is to be informed that progress has been made
-	Diff       []byte    // the diff (previously requested by calling Core.Diff) to be shown to the user
+	DiffChunk  []byte    // the next piece of the diffs (requested by calling Core.Diff) to be shown to the user
+	DiffDone   bool      // if true, the diffs are complete (only if there has been a DiffChunk)
 	Input      []byte    // to be written to Unison's stdin
 	Interrupt  bool      // if true, the Unison process is to be interrupted
 	Kill       bool      // if true, the Unison process is to be killed
+	Restart    bool      // if true, Unison is to be started again with the same arguments
+	Replanned  bool      // if true, Items have been changed by the Core and must be displayed anew
 	Messages   []Message // to be shown to the user
 	Alert      Alert     // to be shown to the user if non-zero
 }
@@ -86,16 +121,16 @@ type Update struct {
 func (upd Update) join(other Update) Update {
 	upd = Update{
 		Progressed: upd.Progressed || other.Progressed,
-		Diff:       upd.Diff,
+		DiffChunk:  append(upd.DiffChunk, other.DiffChunk...),
+		DiffDone:   upd.DiffDone || other.DiffDone,
 		Input:      append(upd.Input, other.Input...),
 		Interrupt:  upd.Interrupt || other.Interrupt,
 		Kill:       upd.Kill || other.Kill,
+		Restart:    upd.Restart || other.Restart,
+		Replanned:  upd.Replanned || other.Replanned,
 		Messages:   append(upd.Messages, other.Messages...),
 		Alert:      upd.Alert,
 	}
-	if other.Diff != nil {
-		upd.Diff = other.Diff // should never both be non-nil
-	}
 	if other.Alert.Text != "" {
 		if upd.Alert.Text != "" {
 			panic("cannot join two Updates with non-zero Alert")
@@ -105,12 +140,22 @@ func (upd Update) join(other Update) Update {
 	return upd
 }
 
+// A Root is the location of a replica. Unison's own hostname for the local machine is that machine's
+// name (unless overridden with UNISONLOCALHOSTNAME), so a Root whose Host is that name is local.
+type Root struct {
+	Host string
+	Path string // as Unison prints it, e.g. /home/user/Documents
+}
+
 // An Item is what will be synchronized by Unison.
 type Item struct {
 	Path           string
 	Left, Right    Content
 	Override       Action // set explicitly by the user (if any)
+	Rule           *Rule  // that has set Override automatically (if any); cleared when the user sets it
 	Recommendation Action // original from Unison
+	Result         Result // what happened to the item during synchronization (if started)
+	Error          string // Unison's explanation of why the item can't be synchronized (for Problem)
 }
 
 func (it Item) Action() Action {
@@ -124,11 +169,43 @@ func (it Item) IsOverridden() bool {
 	return it.Override != NoAction
 }
 
+// IsAutomatic reports whether Override is as set by Rule (rather than changed by the user since).
+func (it Item) IsAutomatic() bool {
+	return it.Rule != nil && it.Override == it.Rule.Action
+}
+
+// CanSet reports whether act may be set as the Override of it. Unison won't synchronize an item
+// with a problem no matter what, and it can only merge files.
+func (it Item) CanSet(act Action) bool {
+	if it.Recommendation == Problem {
+		return false
+	}
+	return act != Merge || (it.Left.Type == File && it.Right.Type == File)
+}
+
+// ForgetOverridesOutside clears Override of those items that are not equal to or contained
+// in any of paths. Nil paths means the entire replica, so nothing is cleared.
+func ForgetOverridesOutside(items []Item, paths []string) {
+	if paths == nil {
+		return
+	}
+itemsLoop:
+	for i := range items {
+		for _, path := range paths {
+			if items[i].Path == path || PathIsAncestor(path, items[i].Path) {
+				continue itemsLoop
+			}
+		}
+		items[i].Override = NoAction
+	}
+}
+
 // Content describes an Item in one of the replicas.
 type Content struct {
-	Type   Type
-	Status Status // zero if unknown (only when Type == Absent)
-	Props  string // human-readable description of properties
+	Type       Type
+	Status     Status // zero if unknown (only when Type == Absent or when Type is unknown, too)
+	Props      string // human-readable description of properties
+	Properties        // parsed from Props
 }
 
 type Type byte
@@ -160,9 +237,27 @@ const (
 	RightToLeftPartial
 	Merge
 	Skip
+	Problem // Unison has encountered an error with this item and will not synchronize it
 	Mixed
 )
 
+// Result describes the outcome of propagating an Item, as reported by Unison.
+type Result struct {
+	Outcome Outcome
+	Reason  string // why the item failed or was skipped, if known
+}
+
+type Outcome byte
+
+const (
+	NoOutcome Outcome = iota // synchronization has not started
+	Pending
+	InProgress
+	Done
+	Failed
+	Skipped
+)
+
 type Message struct {
 	Text       string
 	Importance Importance
@@ -210,13 +305,27 @@ func (c *Core) next() Update {
 	return upd
 }
 
-// ProcStart must be called when the Unison process is started.
+// ProcStart must be called when the Unison process is started. This may be a new process
+// after the previous one has exited, in which case the user's overrides are carried over
+// to the new plan (except for items that have already been synchronized). Overrides set by Rules
+// are not carried over, but the Rules are applied to the new plan anew.
 func (c *Core) ProcStart() Update {
+	var overrides []Item
+	for _, item := range c.Items {
+		if item.IsOverridden() && !item.IsAutomatic() &&
+			(item.Result.Outcome == NoOutcome || item.Result.Outcome == Failed) {
+			overrides = append(overrides, item)
+		}
+	}
+	c.Left, c.Right, c.Items, c.exitCodes, c.ignored, c.prompts = "", "", nil, nil, nil, nil
+	c.LeftRoot, c.RightRoot = Root{}, Root{}
 	return c.transition(Core{
 		Running: true,
 		Busy:    true,
 		Status:  "Starting Unison",
 
+		overrides: overrides,
+
 		procBuffer: c.procBufferStartup,
 		procError:  c.procErrorUnrecoverable,
 
@@ -249,9 +358,31 @@ func (c *Core) ProcExit(code int, err error) Update {
 	if s, ok := c.exitCodes[code]; ok {
 		status = s
 	}
+	if c.restart {
+		// Unison's output and exit status are of no interest: we asked it to quit.
+		return c.transition(Core{
+			Busy:   true,
+			Status: "Restarting Unison",
+
+			procError: c.procErrorBeforeStart,
+		}).join(Update{Restart: true})
+	}
+	var retry []string
+	if c.exitCodes != nil && code == 2 {
+		for _, item := range c.Items {
+			if item.Result.Outcome == Failed {
+				retry = append(retry, item.Path)
+			}
+		}
+	}
 	return echo(output, Info).
 		join(echoError(err)).
-		join(c.transition(Core{Status: status}))
+		join(c.transition(Core{
+			Status:     status,
+			RetryPaths: retry,
+
+			procError: c.procErrorBeforeStart, // in case the user starts Unison again
+		}))
 }
 
 // ProcError must be called when an I/O error happens with Unison.
@@ -262,6 +393,18 @@ func (c *Core) ProcError(err error) Update {
 	return c.procError(err)
 }
 
+func (c *Core) rescan() Update {
+	return Update{Input: []byte("q\n")}.join(c.transition(Core{
+		Running: true,
+		Busy:    true,
+		Status:  "Quitting Unison to rescan",
+		restart: true,
+
+		Interrupt: c.interrupt,
+		Kill:      c.kill,
+	}))
+}
+
 func (c *Core) quit() Update {
 	return Update{Input: []byte("q\n")}.join(c.transition(Core{
 		Running: true,
@@ -333,7 +476,7 @@ var (
 	patPressReturn        = "Press return to continue\\." + patPrompt
 	patContactingServer   = line("Unison [^:\n]+: (Contacting server)\\.\\.\\.")
 	patPermissionDenied   = line("Permission denied, please try again\\.")
-	patConnected          = line("Connected \\[[^\\]]+\\]")
+	patConnected          = line("Connected \\[(?://([^/\\]]*)/(.+?) -> //([^/\\]]*)/(.+?)|[^\\]]+)\\]")
 	patLookingForChanges  = line("(Looking for changes)")
 	patFileProgress       = lineBgn + "[-/|\\\\] ([^\r\n]+)"
 	patFileProgressCont   = "^[^\r\n]+"
@@ -347,13 +490,16 @@ var (
 	patItemHeader      = line(patItem)
 	patItemSideInfo    = " : (?:(absent|deleted)|" + AnyOf(parseTypeStatus) + "  (.*?))"
 
+	patIgnoring = line(" *Permanently ignoring .*?")
+
 	// Unison prefixes diff output with a blank line, the command line, and two more blank lines.
 	patDiffHeader = lineBgn + "\r?\n.+?\r?\n\r?\n"
 
 	patProceedUpdates             = lineBgn + "Proceed with propagating updates\\?" + patPrompt
 	patPropagatingUpdates         = line("(Propagating updates)")
 	patStartedFinishedPropagating = line("(UNISON|Unison) [0-9.]+ \\((OCAML|ocaml) [0-9.]+\\) (?:started|finished) propagating changes at .*?")
-	patSyncThreadStatus           = line("\\[(?:BGN|END|CONFLICT)\\] .*?")
+	patSyncThreadStatus           = line("\\[(BGN|END|CONFLICT)\\] (?:Updating file|Copying properties for|Copying|Deleting|Skipping|Merging) (.*?)")
+	patSyncFailed                 = line("Failed \\[(.*?)\\]: (.*?)")
 	patSyncProgress               = lineBgn + " *([0-9]+)%  (?:[0-9]+:[0-9]{2}|--:--) ETA"
 	patMergeNoise                 = line("(?:Merge command: .*?" +
 		"|Merge result \\(exited \\(0\\)\\):\n.*?" +
@@ -363,9 +509,12 @@ var (
 		"|Merge program (?:made files equal|changed just (?:first|second) input)" +
 		"|Merge program changed both of its inputs in different ways, but returned zero\\." +
 		"|No outputs and (?:first|second) replica has been deleted *)")
-	patWhySkipped  = line(" *(?:conflicting updates|skip requested|(?:contents|properties) changed on both sides)")
+	patWhySkipped  = line(" *(conflicting updates|skip requested|(?:contents|properties) changed on both sides)")
 	patShortcut    = line("Shortcut: .+")
 	patSavingState = line("(Saving synchronizer state)")
+	patSyncSummary = line("Synchronization (?:complete|incomplete) at .*?")
+	patSkippedItem = line(" *skipped: (.*) \\(([^()]*)\\)")
+	patFailedItem  = line(" *failed: (.*?)")
 )
 
 var parseAction = map[string]Action{
@@ -381,7 +530,7 @@ var parseAction = map[string]Action{
 	"<=?==": RightToLeftPartial,
 	"<-M->": Merge,
 	"<=M=>": Merge,
-	// TODO: "error"
+	"error": Problem,
 }
 
 var sendAction = map[Action][]byte{
@@ -394,6 +543,7 @@ var sendAction = map[Action][]byte{
 	// they are Unison's recommendations and we just accept them.
 	LeftToRightPartial: []byte("\n"),
 	RightToLeftPartial: []byte("\n"),
+	Problem:            []byte("\n"), // Unison will not propagate it anyway
 }
 
 var parseTypeStatus = map[string]struct {
@@ -456,6 +606,11 @@ func (c *Core) procBufferStartup() Update {
 	case &patPermissionDenied:
 		return upd.join(c.next())
 
+	case &patConnected:
+		c.LeftRoot = Root{Host: m[1], Path: m[2]}
+		c.RightRoot = Root{Host: m[3], Path: m[4]}
+		return upd.join(c.next())
+
 	case &patFileProgress:
 		upd.Progressed = true
 		c.Progress = m[1]
@@ -504,6 +659,7 @@ func (c *Core) procBufferFileProgress() Update {
 
 func (c *Core) makeProcBufferPlan() func() Update {
 	items := make([]Item, 0)
+	overrides := c.overrides
 	patItemSide := line("(" + regexp.QuoteMeta(c.Left) + "|" + regexp.QuoteMeta(c.Right) + ") *" +
 		patItemSideInfo)
 	expPlan := makeExpecter(true, &patItemHeader, &patItemSide, &patItemPrompt)
@@ -512,7 +668,11 @@ func (c *Core) makeProcBufferPlan() func() Update {
 		pat, m, upd, extra := expPlan(&c.buf)
 		extra = strings.TrimSpace(extra)
 		if extra != "" {
-			return upd.join(c.fatalf(true, "Cannot parse the following output from Unison:\n%s", extra))
+			// Instead of details on each replica, Unison prints the error message for an erroneous item.
+			if len(items) == 0 || items[len(items)-1].Recommendation != Problem || items[len(items)-1].Error != "" {
+				return upd.join(c.fatalf(true, "Cannot parse the following output from Unison:\n%s", extra))
+			}
+			items[len(items)-1].Error = extra
 		}
 
 		switch pat {
@@ -549,12 +709,18 @@ func (c *Core) makeProcBufferPlan() func() Update {
 				side.Type = ts.Type
 				side.Status = ts.Status
 				side.Props = m[4]
+				side.Properties = ParseProperties(m[4])
 			}
 			return upd.join(c.next())
 
 		case &patItemPrompt:
 			c.Items = items
-			return upd.join(c.transitionToReady())
+			c.prompts = make(map[string]int, len(items))
+			for i, item := range items {
+				c.prompts[item.Path] = i
+			}
+			ApplyRules(c.Items, c.Rules) // before the user's own overrides, which take precedence
+			return upd.join(c.applyOverrides(overrides)).join(c.transitionToReady())
 
 		default:
 			return upd
@@ -570,13 +736,53 @@ func (c *Core) transitionToReady() Update {
 		procError: c.procErrorUnrecoverable,
 
 		Diff:      c.diff,
+		Ignore:    c.ignore,
 		Sync:      c.sync,
 		Quit:      c.quit,
+		Rescan:    c.rescan,
 		Interrupt: c.interrupt,
 		Kill:      c.kill,
 	})
 }
 
+// applyOverrides sets the Override of c.Items to that of the corresponding overrides (by Path),
+// which come from a previous plan. An override is not applied if the item's contents have changed
+// since then, because the user's decision may no longer be valid.
+func (c *Core) applyOverrides(overrides []Item) Update {
+	if len(overrides) == 0 {
+		return Update{}
+	}
+	findItem := c.makeFindItem()
+	var changed, missing []string
+	for _, old := range overrides {
+		item := findItem(old.Path)
+		switch {
+		case item == nil:
+			missing = append(missing, old.Path)
+		case item.Left != old.Left || item.Right != old.Right:
+			changed = append(changed, old.Path)
+		case item.Action() != Problem:
+			item.Override, item.Rule = old.Override, nil // the user's choice, even if a rule agrees
+		}
+	}
+	var upd Update
+	if changed != nil {
+		upd.Messages = append(upd.Messages, Message{
+			"These items have changed, so the actions you set for them were not carried over:\n" +
+				strings.Join(changed, "\n"),
+			Warning,
+		})
+	}
+	if missing != nil {
+		upd.Messages = append(upd.Messages, Message{
+			"These items are no longer in the plan, so the actions you set for them were discarded:\n" +
+				strings.Join(missing, "\n"),
+			Info,
+		})
+	}
+	return upd
+}
+
 func (c *Core) restorePrompt() Update {
 	return c.transition(Core{
 		Running: true,
@@ -603,7 +809,27 @@ func (c *Core) procBufferRestorePrompt() Update {
 	}
 }
 
-func (c *Core) diff(path string) Update {
+func (c *Core) diff(paths ...string) Update {
+	if len(paths) == 0 {
+		return Update{}
+	}
+	// We want to go through Unison's prompts once, so we follow their order, which Items
+	// may no longer be in (the UI sorts them).
+	position := func(path string) int {
+		if i, ok := c.prompts[path]; ok {
+			return i
+		}
+		return len(c.prompts) // we'll fail to find it anyway
+	}
+	queue := append([]string(nil), paths...)
+	sort.SliceStable(queue, func(i, j int) bool { return position(queue[i]) < position(queue[j]) })
+	for i := 1; i < len(queue); i++ {
+		if queue[i] == queue[i-1] {
+			queue = append(queue[:i], queue[i+1:]...)
+			i--
+		}
+	}
+
 	return Update{Input: []byte("0\n")}.join(c.transition(Core{
 		Running: true,
 		Busy:    true,
@@ -616,7 +842,8 @@ func (c *Core) diff(path string) Update {
 		Interrupt: c.interrupt,
 		Kill:      c.kill,
 
-		seek: path,
+		seek:      queue[0],
+		diffQueue: queue[1:],
 	}))
 }
 
@@ -654,26 +881,190 @@ func (c *Core) procBufferDiffBegin() Update {
 	case &patItemPrompt:
 		return upd.
 			join(echo(extra, Error)).
-			join(c.transitionToReady())
+			join(c.diffNext())
 
 	default:
 		return upd.join(echo(extra, Info))
 	}
 }
 
-var expDiffOutput = makeExpecter(true, &patItemPrompt)
+var regexpItemPrompt = regexp.MustCompile(patItemPrompt)
 
+// procBufferDiffOutput passes diff output on to the UI as it arrives, in DiffChunks, so that c.buf
+// doesn't grow with the entire diff and the UI can start displaying it. When the diff is over,
+// Unison prints the item prompt and waits for input, so the prompt can only be at the very end
+// of the buffer, and it suffices to look for it in the last line. A last line that is too long
+// to be the prompt is passed on, too, so that c.buf doesn't grow with a long line either.
 func (c *Core) procBufferDiffOutput() Update {
-	switch pat, _, upd, out := expDiffOutput(&c.buf); pat {
-	case &patItemPrompt:
-		if strings.TrimSpace(out) != "" {
-			upd.Diff = []byte(out)
-		}
-		return upd.join(c.transitionToReady())
+	// The prompt we're waiting for is for c.seek, and the rest of it is short.
+	maxPrompt := len(c.seek) + 100
+
+	data := c.buf.Bytes()
+	last := bytes.LastIndexAny(data, "\r\n")
+	inLine := last == -1 && c.diffInLine // all of data continues a line that has been passed on
+	if last == -1 {
+		last = 0
+	}
+	done := false
+	if inLine || len(data)-last > maxPrompt {
+		last = len(data)
+	} else {
+		m := regexpItemPrompt.FindSubmatch(data[last:])
+		done = m != nil && string(m[2]) == c.seek
+	}
 
-	default:
+	var upd Update
+	// There may be no output at all, if the diff command is a GUI one. Don't bother the UI
+	// until there's something besides whitespace.
+	if chunk := data[:last]; c.diffBegun || len(bytes.TrimSpace(chunk)) > 0 {
+		c.diffBegun, c.diffAny = true, true
+		upd.DiffChunk = append([]byte(nil), chunk...) // c.buf will reuse its memory
+		c.buf.Next(last)
+		c.diffInLine = last == len(data) && last > 0
+	}
+	if !done {
 		return upd
 	}
+	c.buf.Reset()
+	return upd.join(c.diffNext())
+}
+
+// diffNext moves on to the next path in c.diffQueue, or finishes when there are no more.
+// Unison must be at the prompt for the item just diffed.
+func (c *Core) diffNext() Update {
+	if len(c.diffQueue) == 0 {
+		done := c.diffAny // before it's reset by the transition
+		return Update{DiffDone: done}.join(c.transitionToReady())
+	}
+	c.seek, c.diffQueue = c.diffQueue[0], c.diffQueue[1:]
+	c.diffBegun, c.diffInLine = false, false
+	c.procBuffer = c.procBufferDiffSeek
+	c.Abort = c.restorePrompt
+	return Update{Input: []byte("n\n")}.join(c.next())
+}
+
+// An Ignore specifies which items Unison is to ignore permanently, relative to a given item.
+type Ignore byte
+
+const (
+	IgnorePath Ignore = iota + 1 // the item itself
+	IgnoreName                   // all items with the same name
+	IgnoreExt                    // all items with the same extension
+)
+
+// Unison's keys for these are capital letters.
+var sendIgnore = map[Ignore][]byte{
+	IgnorePath: []byte("I\n"),
+	IgnoreName: []byte("N\n"),
+	IgnoreExt:  []byte("E\n"),
+}
+
+// IgnoredName returns the name of the file or directory at path, which Unison will ignore
+// everywhere on IgnoreName.
+func IgnoredName(path string) string {
+	return path[strings.LastIndexByte(path, '/')+1:]
+}
+
+// IgnoredExt returns the extension (without the dot) of the file or directory at path,
+// which Unison will ignore everywhere on IgnoreExt. If the name has no extension,
+// IgnoredExt returns false, and Unison will ignore the name instead.
+func IgnoredExt(path string) (string, bool) {
+	name := IgnoredName(path)
+	i := strings.LastIndexByte(name, '.')
+	if i == -1 {
+		return "", false
+	}
+	return name[i+1:], true
+}
+
+// ignoreMatcher returns a function that reports whether Unison will ignore an item
+// once told to ignore path as specified by what.
+func ignoreMatcher(path string, what Ignore) func(string) bool {
+	if what == IgnorePath {
+		return func(p string) bool { return p == path || PathIsAncestor(path, p) }
+	}
+	// Unison's Name patterns apply to the last component, but an ignored directory
+	// also hides everything inside it.
+	match := func(name string) bool { return name == IgnoredName(path) }
+	if ext, ok := IgnoredExt(path); ok && what == IgnoreExt {
+		match = func(name string) bool { return strings.HasSuffix(name, "."+ext) }
+	}
+	return func(p string) bool {
+		for _, name := range strings.Split(p, "/") {
+			if match(name) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (c *Core) ignore(path string, what Ignore) Update {
+	return Update{Input: []byte("0\n")}.join(c.transition(Core{
+		Running: true,
+		Busy:    true,
+		Status:  "Adding ignore rule",
+
+		procBuffer: c.makeProcBufferIgnore(path, what),
+		procError:  c.procErrorUnrecoverable,
+
+		Abort:     c.restorePrompt,
+		Interrupt: c.interrupt,
+		Kill:      c.kill,
+	}))
+}
+
+var expIgnore = makeExpecter(true, &patIgnoring, &patItemPrompt, &patProceedUpdates)
+
+func (c *Core) makeProcBufferIgnore(path string, what Ignore) func() Update {
+	sent := false
+	return func() Update {
+		if !sent {
+			switch pat, m, upd, _ := expSeek(&c.buf); pat {
+			case &patItemPrompt:
+				if m[2] == path { // found the item to ignore
+					upd.Input = sendIgnore[what]
+					sent = true
+					c.Abort = nil // the rule may already be in the profile
+				} else {
+					upd.Input = []byte("n\n")
+				}
+				return upd.join(c.next())
+
+			case &patProceedUpdates: // there's no next item to seek to
+				return upd.join(c.fatalf(false, "Failed to find '%s' in Unison prompts.", path))
+
+			default:
+				return upd
+			}
+		}
+
+		switch pat, _, upd, extra := expIgnore(&c.buf); pat {
+		case &patIgnoring:
+			return upd.join(echo(extra, Info)).join(c.next())
+
+		case &patItemPrompt, &patProceedUpdates:
+			upd = upd.join(echo(extra, Info))
+			matches := ignoreMatcher(path, what)
+			items := make([]Item, 0, len(c.Items))
+			for _, item := range c.Items {
+				if !matches(item.Path) {
+					items = append(items, item)
+				}
+			}
+			if ignored := c.ignored; ignored != nil {
+				c.ignored = func(p string) bool { return ignored(p) || matches(p) }
+			} else {
+				c.ignored = matches
+			}
+			c.Items = items
+			upd.Replanned = true
+			return upd.join(c.transitionToReady())
+
+		default:
+			return upd.join(echo(extra, Info))
+		}
+	}
 }
 
 func (c *Core) sync() Update {
@@ -698,19 +1089,26 @@ func (c *Core) makeProcBufferStartSync() func() Update {
 	for _, item := range c.Items {
 		plan[item.Path] = item.Action()
 	}
+	var lastPath string
 
 	return func() Update {
 		pat, m, upd, extra := expStartSync(&c.buf)
 		extra = strings.TrimSpace(extra)
-		if extra != "" {
-			// Any unexpected output at this crucial phase is too risky to ignore (echo).
+		// Any unexpected output at this crucial phase is too risky to ignore (echo).
+		// The only output we expect is the error message that Unison may repeat for an erroneous item.
+		if extra != "" && plan[lastPath] != Problem {
 			return upd.join(c.fatalf(false, "Cannot parse the following output from Unison:\n%s", extra))
 		}
 
 		switch pat {
 		case &patItemPrompt:
 			path := m[2]
+			lastPath = path
 			act, ok := plan[path]
+			if !ok && c.ignored != nil && c.ignored(path) {
+				// Unison may still prompt for items that match an ignore rule added just now.
+				act, ok = Skip, true
+			}
 			if !ok {
 				return upd.join(c.fatalf(false,
 					"Failed to start synchronization because this path is missing from Gunison's plan: %s",
@@ -720,16 +1118,25 @@ func (c *Core) makeProcBufferStartSync() func() Update {
 			return upd.join(c.next())
 
 		case &patItemHeader:
+			lastPath = m[2]
 			return upd.join(c.next())
 
 		case &patProceedUpdates:
 			upd.Input = []byte("y\n")
+			for i := range c.Items {
+				if item := &c.Items[i]; item.Recommendation == Problem {
+					// Unison will not even report on such items, so we already know what will happen.
+					item.Result = Result{Skipped, item.Error}
+				} else {
+					item.Result = Result{Outcome: Pending}
+				}
+			}
 			return upd.join(c.transition(Core{
 				Running: true,
 				Busy:    true,
 				Status:  "Starting synchronization",
 
-				procBuffer: c.procBufferSync,
+				procBuffer: c.makeProcBufferSync(),
 				exitCodes: map[int]string{
 					// These codes, documented in the Unison manual, actually take on their meaning
 					// only after synchronization begins.
@@ -750,35 +1157,166 @@ func (c *Core) makeProcBufferStartSync() func() Update {
 }
 
 var expSync = makeExpecter(false, &patPropagatingUpdates, &patStartedFinishedPropagating,
-	&patSyncThreadStatus, &patSyncProgress, &patMergeNoise, &patWhySkipped, &patShortcut,
-	&patSavingState, &patSomeLine)
+	&patSyncThreadStatus, &patSyncFailed, &patSyncProgress, &patMergeNoise, &patWhySkipped, &patShortcut,
+	&patSavingState, &patSyncSummary, &patSkippedItem, &patFailedItem, &patSomeLine)
+
+func (c *Core) makeProcBufferSync() func() Update {
+	findItem := c.makeFindItem()
+	var lastSkipped string // path of the item from the last [CONFLICT] line, for patWhySkipped
+	var lastFailed string  // path of the item from the last Failed line, for its continuation lines
+	var continued bool     // whether the lines since the last Failed line may be its continuation
+
+	// Unison separates the path from the replica roots with nothing but " from ", which may also
+	// occur in the path itself, so we try every occurrence until we find a path that we know.
+	findItemFrom := func(s string) *Item {
+		if item := findItem(s); item != nil {
+			return item
+		}
+		for i := 0; ; {
+			j := strings.Index(s[i:], " from ")
+			if j == -1 {
+				return nil
+			}
+			if item := findItem(s[:i+j]); item != nil {
+				return item
+			}
+			i += j + 1
+		}
+	}
 
-func (c *Core) procBufferSync() Update {
-	switch pat, m, upd, _ := expSync(&c.buf); pat {
-	case &patPropagatingUpdates, &patSavingState:
-		c.Status = m[1]
-		c.Progress = ""
-		c.ProgressFraction = 0
-		return upd.join(c.next())
+	setResult := func(item *Item, outcome Outcome, reason string) {
+		if item == nil {
+			return
+		}
+		if reason == "" && outcome == item.Result.Outcome {
+			reason = item.Result.Reason
+		}
+		item.Result = Result{outcome, reason}
+	}
 
-	case &patSyncProgress:
-		c.Progress = strings.TrimSpace(m[0])
-		percent, _ := strconv.Atoi(m[1])
-		c.ProgressFraction = float64(percent) / 100
-		return upd.join(c.next())
+	return func() Update {
+		// Unison may wrap the reason for a failure onto several lines, which we only know are over
+		// when we see a line that we recognize.
+		wasContinued := continued
+		continued = false
+
+		switch pat, m, upd, _ := expSync(&c.buf); pat {
+		case &patPropagatingUpdates, &patSavingState:
+			c.Status = m[1]
+			c.Progress = ""
+			c.ProgressFraction = 0
+			return upd.join(c.next())
 
-	case &patSomeLine: // something we don't explicitly recognize and consume
-		// (it's not enough to rely on makeExpecter's echo because
-		// at this point we want to echo lines as soon as they come)
-		return upd.
-			join(echo(m[1], Info)).
-			join(c.next())
+		case &patSyncProgress:
+			c.Progress = strings.TrimSpace(m[0])
+			percent, _ := strconv.Atoi(m[1])
+			c.ProgressFraction = float64(percent) / 100
+			return upd.join(c.next())
 
-	case nil:
-		return upd
+		case &patSyncThreadStatus:
+			switch m[1] {
+			case "BGN":
+				if item := findItemFrom(m[2]); item != nil && item.Result.Outcome == Pending {
+					setResult(item, InProgress, "")
+				}
+			case "END":
+				if item := findItem(m[2]); item != nil && item.Result.Outcome == InProgress {
+					setResult(item, Done, "")
+				}
+			case "CONFLICT":
+				setResult(findItem(m[2]), Skipped, "")
+				lastSkipped = m[2]
+			}
+			return upd.join(c.next())
 
-	default: // all the noise we recognize and ignore, such as patWhySkipped, etc.
-		return upd.join(c.next())
+		case &patWhySkipped:
+			if item := findItem(lastSkipped); item != nil && item.Result.Outcome == Skipped {
+				setResult(item, Skipped, m[1])
+			}
+			lastSkipped = ""
+			return upd.join(c.next())
+
+		case &patSyncFailed:
+			setResult(findItem(m[1]), Failed, m[2])
+			lastFailed = m[1]
+			continued = true
+			return upd.
+				join(echo(m[0], Info)).
+				join(c.next())
+
+		case &patSyncSummary:
+			// Unison only gets here after it has finished with all items, and it's about to list
+			// those that were skipped or failed. Others, including those it didn't bother to report
+			// (such as merges), must have been propagated.
+			for i := range c.Items {
+				if item := &c.Items[i]; item.Result.Outcome == Pending || item.Result.Outcome == InProgress {
+					setResult(item, Done, "")
+				}
+			}
+			return upd.
+				join(echo(m[0], Info)).
+				join(c.next())
+
+		case &patSkippedItem:
+			setResult(findItem(m[1]), Skipped, m[2])
+			return upd.
+				join(echo(m[0], Info)).
+				join(c.next())
+
+		case &patFailedItem:
+			setResult(findItem(m[1]), Failed, "")
+			return upd.
+				join(echo(m[0], Info)).
+				join(c.next())
+
+		case &patSomeLine: // something we don't explicitly recognize and consume
+			if text := strings.TrimSpace(m[1]); wasContinued && text != "" {
+				if item := findItem(lastFailed); item != nil && item.Result.Outcome == Failed {
+					item.Result.Reason += " " + text
+				}
+				continued = true
+			}
+			// (it's not enough to rely on makeExpecter's echo because
+			// at this point we want to echo lines as soon as they come)
+			return upd.
+				join(echo(m[1], Info)).
+				join(c.next())
+
+		case nil:
+			continued = wasContinued // haven't seen the next line yet
+			return upd
+
+		default: // all the noise we recognize and ignore, such as patMergeNoise, etc.
+			return upd.join(c.next())
+		}
+	}
+}
+
+// makeFindItem returns a function that finds the Item with the given Path in c.Items, or returns nil.
+func (c *Core) makeFindItem() func(string) *Item {
+	var index map[string]int
+	rebuild := func() {
+		index = make(map[string]int, len(c.Items))
+		for i, item := range c.Items {
+			index[item.Path] = i
+		}
+	}
+	return func(path string) *Item {
+		if index == nil {
+			rebuild()
+		}
+		i, ok := index[path]
+		if !ok {
+			return nil
+		}
+		// The UI is free to reorder c.Items (for example, when sorting), so the index may be stale.
+		if i >= len(c.Items) || c.Items[i].Path != path {
+			rebuild()
+			if i, ok = index[path]; !ok {
+				return nil
+			}
+		}
+		return &c.Items[i]
 	}
 }
 
@@ -3,10 +3,12 @@ package main
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/gotk3/gotk3/gtk"
@@ -66,8 +68,8 @@ func TestMinimal(t *testing.T) {
 	assertEqual(t, c.Items, []Item{
 		{
 			Path:           "one",
-			Left:           Content{File, Modified, "modified on 2021-02-08 at 18:30:50  size 1146      rw-r--r--"},
-			Right:          Content{File, Unchanged, "modified on 2021-02-08 at 18:30:50  size 1146      rw-r--r--"},
+			Left:           content(File, Modified, "modified on 2021-02-08 at 18:30:50  size 1146      rw-r--r--"),
+			Right:          content(File, Unchanged, "modified on 2021-02-08 at 18:30:50  size 1146      rw-r--r--"),
 			Recommendation: LeftToRight,
 		},
 	})
@@ -90,12 +92,15 @@ func TestMinimal(t *testing.T) {
 	assert.Zero(t, c.ProcOutput([]byte("Propagating updates\n")))
 	assertEqual(t, c.Status, "Propagating updates")
 	assert.Zero(t, c.ProcOutput([]byte("\n\nUNISON 2.51.3 (OCAML 4.11.1) started propagating changes at 18:31:20.92 on 08 Feb 2021\n")))
+	assertEqual(t, c.Items[0].Result, Result{Outcome: Pending})
 	assert.Zero(t, c.ProcOutput([]byte("[BGN] Updating file one from /home/vasiliy/tmp/gunison/left to /home/vasiliy/tmp/gunison/right\n")))
+	assertEqual(t, c.Items[0].Result, Result{Outcome: InProgress})
 	assert.Zero(t, c.ProcOutput([]byte("100%  00:00 ETA")))
 	assertEqual(t, c.Progress, "100%  00:00 ETA")
 	assertEqual(t, c.ProgressFraction, 1.00)
 	assert.Zero(t, c.ProcOutput([]byte("\r               \r")))
 	assert.Zero(t, c.ProcOutput([]byte("[END] Updating file one\n")))
+	assertEqual(t, c.Items[0].Result, Result{Outcome: Done})
 	assert.Zero(t, c.ProcOutput([]byte("100%  00:00 ETA")))
 	assert.Zero(t, c.ProcOutput([]byte("\r               \r")))
 	assert.Zero(t, c.ProcOutput([]byte("UNISON 2.51.3 (OCAML 4.11.1) finished propagating changes at 18:31:20.92 on 08 Feb 2021\n\n\n")))
@@ -119,6 +124,7 @@ func TestMinimal(t *testing.T) {
 	assert.False(t, c.Busy)
 	assert.False(t, c.Running)
 	assert.NotNil(t, c.Items)
+	assert.Nil(t, c.RetryPaths)
 }
 
 func TestTerse(t *testing.T) { // unison -terse
@@ -174,6 +180,154 @@ func TestQuit(t *testing.T) {
 	assert.False(t, c.Running)
 }
 
+func TestRescan(t *testing.T) {
+	c := initCoreMinimalReady(t)
+	c.Items[0].Override = Skip
+	assertEqual(t, c.Rescan(),
+		Update{Input: []byte("q\n")})
+	assertEqual(t, c.Status, "Quitting Unison to rescan")
+	assert.True(t, c.Busy)
+	assert.Nil(t, c.Rescan)
+	assert.Zero(t, c.ProcOutput([]byte("Terminated!\n")))
+	assertEqual(t, c.ProcExit(3, errors.New("exit status 3")),
+		Update{Restart: true})
+	assertEqual(t, c.Status, "Restarting Unison")
+	assert.False(t, c.Running)
+
+	assert.Zero(t, c.ProcStart())
+	assert.Empty(t, c.Left)
+	assert.Nil(t, c.Items)
+	assert.Zero(t, c.ProcOutput([]byte("\nleft           right              \n")))
+	assertEqual(t, c.ProcOutput([]byte("changed  ---->            one  [f] ")),
+		Update{Input: []byte("l\n")})
+	assert.Zero(t, c.ProcOutput([]byte("changed  ---->            one  \n")))
+	assert.Zero(t, c.ProcOutput([]byte("left         : changed file       modified on 2021-02-07 at  1:50:31  size 1146      rw-r--r--\nright        : unchanged file     modified on 2021-02-07 at  1:50:31  size 1146      rw-r--r--\n")))
+	assert.Zero(t, c.ProcOutput([]byte("changed  ---->            one  [f] ")))
+	assertEqual(t, c.Status, "Ready to synchronize")
+	assertEqual(t, c.Items, []Item{
+		{
+			Path:           "one",
+			Left:           content(File, Modified, "modified on 2021-02-07 at  1:50:31  size 1146      rw-r--r--"),
+			Right:          content(File, Unchanged, "modified on 2021-02-07 at  1:50:31  size 1146      rw-r--r--"),
+			Override:       Skip,
+			Recommendation: LeftToRight,
+		},
+	})
+}
+
+func TestRescanChanged(t *testing.T) {
+	c := initCoreMinimalReady(t)
+	c.Items = append(c.Items, Item{
+		Path:           "two",
+		Left:           content(File, Modified, "modified on 2021-02-07 at  1:52:12  size 10      rw-r--r--"),
+		Right:          content(File, Unchanged, "modified on 2021-02-07 at  1:52:12  size 10      rw-r--r--"),
+		Override:       Skip,
+		Recommendation: LeftToRight,
+	})
+	c.Items[0].Override = RightToLeft
+	c.Rescan()
+	assertEqual(t, c.ProcExit(3, errors.New("exit status 3")),
+		Update{Restart: true})
+
+	c.ProcStart()
+	c.ProcOutput([]byte("\nleft           right              \n"))
+	c.ProcOutput([]byte("changed  ---->            one  [f] "))
+	c.ProcOutput([]byte("changed  ---->            one  \n"))
+	c.ProcOutput([]byte("left         : changed file       modified on 2021-02-07 at  1:58:02  size 1150      rw-r--r--\nright        : unchanged file     modified on 2021-02-07 at  1:50:31  size 1146      rw-r--r--\n"))
+	assertEqual(t, c.ProcOutput([]byte("changed  ---->            one  [f] ")),
+		Update{Messages: []Message{
+			{"These items have changed, so the actions you set for them were not carried over:\none", Warning},
+			{"These items are no longer in the plan, so the actions you set for them were discarded:\ntwo", Info},
+		}})
+	assertEqual(t, c.Status, "Ready to synchronize")
+	require.Len(t, c.Items, 1)
+	assertEqual(t, c.Items[0].Override, NoAction)
+}
+
+func TestRescanRules(t *testing.T) {
+	c := initCoreMinimalReady(t)
+	rules, err := ParseRules("skip one")
+	require.NoError(t, err)
+	c.Rules = rules
+	ApplyRules(c.Items, c.Rules)
+	require.True(t, c.Items[0].IsAutomatic())
+	c.Rescan()
+	c.ProcExit(3, errors.New("exit status 3"))
+
+	// The item has changed, but that doesn't matter to the rule, which is applied anew.
+	c.ProcStart()
+	c.ProcOutput([]byte("\nleft           right              \n"))
+	c.ProcOutput([]byte("changed  ---->            one  [f] "))
+	c.ProcOutput([]byte("changed  ---->            one  \n"))
+	c.ProcOutput([]byte("left         : changed file       modified on 2021-02-07 at  1:58:02  size 1150      rw-r--r--\nright        : unchanged file     modified on 2021-02-07 at  1:50:31  size 1146      rw-r--r--\n"))
+	assert.Zero(t, c.ProcOutput([]byte("changed  ---->            one  [f] ")))
+	assertEqual(t, c.Status, "Ready to synchronize")
+	require.Len(t, c.Items, 1)
+	assertEqual(t, c.Items[0].Override, Skip)
+	assert.Same(t, &c.Rules[0], c.Items[0].Rule)
+
+	// But the user's own override takes precedence over the rule.
+	c.Items[0].Override = RightToLeft
+	c.Rescan()
+	c.ProcExit(3, errors.New("exit status 3"))
+	c.ProcStart()
+	c.ProcOutput([]byte("\nleft           right              \n"))
+	c.ProcOutput([]byte("changed  ---->            one  [f] "))
+	c.ProcOutput([]byte("changed  ---->            one  \n"))
+	c.ProcOutput([]byte("left         : changed file       modified on 2021-02-07 at  1:58:02  size 1150      rw-r--r--\nright        : unchanged file     modified on 2021-02-07 at  1:50:31  size 1146      rw-r--r--\n"))
+	assert.Zero(t, c.ProcOutput([]byte("changed  ---->            one  [f] ")))
+	require.Len(t, c.Items, 1)
+	assertEqual(t, c.Items[0].Override, RightToLeft)
+	assert.False(t, c.Items[0].IsAutomatic())
+
+	// Even if it's the same as the rule's action.
+	c.Items[0].Override = Skip
+	c.Rescan()
+	c.ProcExit(3, errors.New("exit status 3"))
+	c.ProcStart()
+	c.ProcOutput([]byte("\nleft           right              \n"))
+	c.ProcOutput([]byte("changed  ---->            one  [f] "))
+	c.ProcOutput([]byte("changed  ---->            one  \n"))
+	c.ProcOutput([]byte("left         : changed file       modified on 2021-02-07 at  1:58:02  size 1150      rw-r--r--\nright        : unchanged file     modified on 2021-02-07 at  1:50:31  size 1146      rw-r--r--\n"))
+	assert.Zero(t, c.ProcOutput([]byte("changed  ---->            one  [f] ")))
+	require.Len(t, c.Items, 1)
+	assertEqual(t, c.Items[0].Override, Skip)
+	assert.False(t, c.Items[0].IsAutomatic())
+}
+
+func TestForgetOverridesOutside(t *testing.T) {
+	paths := []string{"", "docs", "docs/a.txt", "docsx", "src/main.go", "src/util.go"}
+	cases := []struct {
+		name     string
+		paths    []string
+		expected []string // paths whose overrides are kept
+	}{
+		{lineno(), nil, paths},
+		{lineno(), []string{"docs"}, []string{"docs", "docs/a.txt"}},
+		{lineno(), []string{"docs/a.txt", "src/main.go"}, []string{"docs/a.txt", "src/main.go"}},
+		{lineno(), []string{"src"}, []string{"src/main.go", "src/util.go"}},
+		{lineno(), []string{""}, paths},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var items []Item
+			for _, path := range paths {
+				it := item(path)
+				it.Override = Skip
+				items = append(items, it)
+			}
+			ForgetOverridesOutside(items, c.paths)
+			var kept []string
+			for _, it := range items {
+				if it.IsOverridden() {
+					kept = append(kept, it.Path)
+				}
+			}
+			assertEqual(t, kept, c.expected)
+		})
+	}
+}
+
 func TestKilledExternally(t *testing.T) {
 	c := initCoreMinimalReady(t)
 	assertEqual(t, c.ProcExit(-1, errors.New("signal: killed")),
@@ -321,7 +475,7 @@ func TestDiff(t *testing.T) {
 	assertEqual(t, c.ProcOutput([]byte("changed  ---->            file3  [f] ")),
 		Update{Input: []byte("d\n")})
 	assertEqual(t, c.ProcOutput([]byte("\ndiff -u '/home/vasiliy/tmp/gunison/right/file3' '/home/vasiliy/tmp/gunison/left/file3'\n\n--- /home/vasiliy/tmp/gunison/right/file3\t2021-02-13 14:29:12.571303322 +0300\n+++ /home/vasiliy/tmp/gunison/left/file3\t2021-02-13 14:29:12.575303310 +0300\n@@ -1,9 +1,9 @@\n Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n+Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae teonsectetur tempore quia id. Perspiciatis enim corrupti aliquam nam accusamus et molestiae rerum. Sint sit exercitationem corrupti omnis.\n \n-Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspiciatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n+Facere asperiores unde rerum dignissimos id. Nihil maiores sequi accusamus eum repudiandae et. Nesciunt ab inveniatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n \n-Omnis repudiandae nobis autem qui autem possimus. Dolorem id a reprehenderit nihil laboriosam non. Dolor minima in soluta. Magni eveniet magnam velit officia consectetur tempore quia id. Perspiciatis enim corrupti aliquam nam accusamus et molestiae rerum. Sint sit exercitationem corrupti omnis.\n+Omnis repudiandae nobis autem qui autem possimus. Dolorem id a reprehenderit nihil laboriosam non. Dolor minima in soluta. Magni eveniet magnam velit officia cnetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n \n-Facere asperiores unde rerum dignissimos id. Nihil maiores sequi accusamus eum repudiandae et. Nesciunt ab inventore repellat enim illum ratione enim voluptatum. Tempore sint quos tempore fugit rerum sit omnis quae. Minus deserunt aut dolores excepturi qui.\n+Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspictore repellat enim illum ratione enim voluptatum. Tempore sint quos tempore fugit rerum sit omnis quae. Minus deserunt aut dolores excepturi qui.\n\nchanged  ---->            file3  [f] ")),
-		Update{Diff: []byte("--- /home/vasiliy/tmp/gunison/right/file3\t2021-02-13 14:29:12.571303322 +0300\n+++ /home/vasiliy/tmp/gunison/left/file3\t2021-02-13 14:29:12.575303310 +0300\n@@ -1,9 +1,9 @@\n Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n+Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae teonsectetur tempore quia id. Perspiciatis enim corrupti aliquam nam accusamus et molestiae rerum. Sint sit exercitationem corrupti omnis.\n \n-Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspiciatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n+Facere asperiores unde rerum dignissimos id. Nihil maiores sequi accusamus eum repudiandae et. Nesciunt ab inveniatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n \n-Omnis repudiandae nobis autem qui autem possimus. Dolorem id a reprehenderit nihil laboriosam non. Dolor minima in soluta. Magni eveniet magnam velit officia consectetur tempore quia id. Perspiciatis enim corrupti aliquam nam accusamus et molestiae rerum. Sint sit exercitationem corrupti omnis.\n+Omnis repudiandae nobis autem qui autem possimus. Dolorem id a reprehenderit nihil laboriosam non. Dolor minima in soluta. Magni eveniet magnam velit officia cnetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n \n-Facere asperiores unde rerum dignissimos id. Nihil maiores sequi accusamus eum repudiandae et. Nesciunt ab inventore repellat enim illum ratione enim voluptatum. Tempore sint quos tempore fugit rerum sit omnis quae. Minus deserunt aut dolores excepturi qui.\n+Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspictore repellat enim illum ratione enim voluptatum. Tempore sint quos tempore fugit rerum sit omnis quae. Minus deserunt aut dolores excepturi qui.\n")})
+		Update{DiffChunk: []byte("--- /home/vasiliy/tmp/gunison/right/file3\t2021-02-13 14:29:12.571303322 +0300\n+++ /home/vasiliy/tmp/gunison/left/file3\t2021-02-13 14:29:12.575303310 +0300\n@@ -1,9 +1,9 @@\n Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n+Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae teonsectetur tempore quia id. Perspiciatis enim corrupti aliquam nam accusamus et molestiae rerum. Sint sit exercitationem corrupti omnis.\n \n-Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspiciatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n+Facere asperiores unde rerum dignissimos id. Nihil maiores sequi accusamus eum repudiandae et. Nesciunt ab inveniatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n \n-Omnis repudiandae nobis autem qui autem possimus. Dolorem id a reprehenderit nihil laboriosam non. Dolor minima in soluta. Magni eveniet magnam velit officia consectetur tempore quia id. Perspiciatis enim corrupti aliquam nam accusamus et molestiae rerum. Sint sit exercitationem corrupti omnis.\n+Omnis repudiandae nobis autem qui autem possimus. Dolorem id a reprehenderit nihil laboriosam non. Dolor minima in soluta. Magni eveniet magnam velit officia cnetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n \n-Facere asperiores unde rerum dignissimos id. Nihil maiores sequi accusamus eum repudiandae et. Nesciunt ab inventore repellat enim illum ratione enim voluptatum. Tempore sint quos tempore fugit rerum sit omnis quae. Minus deserunt aut dolores excepturi qui.\n+Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspictore repellat enim illum ratione enim voluptatum. Tempore sint quos tempore fugit rerum sit omnis quae. Minus deserunt aut dolores excepturi qui.\n"), DiffDone: true})
 	assertEqual(t, c.Status, "Ready to synchronize")
 	assert.False(t, c.Busy)
 	assert.NotNil(t, c.Sync)
@@ -336,19 +490,26 @@ func TestDiff(t *testing.T) {
 	assertEqual(t, c.ProcOutput([]byte("changed  ---->            file2  [f] ")),
 		Update{Input: []byte("d\n")})
 	assertEqual(t, c.ProcOutput([]byte("\ndiff -u '/home/vasiliy/tmp/gunison/right/file2' '/home/vasiliy/tmp/gunison/left/file2'\n\n--- /home/vasiliy/tmp/gunison/right/file2\t2021-02-13 14:29:12.571303322 +0300\n+++ /home/vasiliy/tmp/gunison/left/file2\t2021-02-13 14:29:12.571303322 +0300\n@@ -1,6 +1,6 @@\n-Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n+Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate t nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n+Consectetur fuga sed vitae eeaque.\n \n Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspiciatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n \n\nchanged  ---->            file2  [f] ")),
-		Update{Diff: []byte("--- /home/vasiliy/tmp/gunison/right/file2\t2021-02-13 14:29:12.571303322 +0300\n+++ /home/vasiliy/tmp/gunison/left/file2\t2021-02-13 14:29:12.571303322 +0300\n@@ -1,6 +1,6 @@\n-Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n+Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate t nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n+Consectetur fuga sed vitae eeaque.\n \n Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspiciatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n \n")})
+		Update{DiffChunk: []byte("--- /home/vasiliy/tmp/gunison/right/file2\t2021-02-13 14:29:12.571303322 +0300\n+++ /home/vasiliy/tmp/gunison/left/file2\t2021-02-13 14:29:12.571303322 +0300\n@@ -1,6 +1,6 @@\n-Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n+Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate t nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n+Consectetur fuga sed vitae eeaque.\n \n Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspiciatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n \n"), DiffDone: true})
 
 	assertEqual(t, c.Diff("file1"),
 		Update{Input: []byte("0\n")})
 	assertEqual(t, c.ProcOutput([]byte("changed  ---->            file1  [f] ")),
 		Update{Input: []byte("d\n")})
 	assertEqual(t, c.ProcOutput([]byte("\ndiff -u '/home/vasiliy/tmp/gunison/right/file1' '/home/vasiliy/tmp/gunison/left/file1'\n\n--- /home/vasiliy/tmp/gunison/right/file1\t2021-02-13 14:29:12.571303322 +0300\n+++ /home/vasiliy/tmp/gunison/left/file1\t2021-02-13 14:29:12.571303322 +0300\n@@ -1,6 +1,6 @@\n-Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n+Quia est unde laboriosam. Eum ullam deleniti dolorrupti maiores optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n+Consectetur fuga sed vitae eut ut sed officiis id. Et aut nostrum est quia cores. Magni quasi facere voluptas. Dolor doloribus at nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n \n Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspiciatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n \n\nchanged  ---->            file1  [f] ")),
-		Update{Diff: []byte("--- /home/vasiliy/tmp/gunison/right/file1\t2021-02-13 14:29:12.571303322 +0300\n+++ /home/vasiliy/tmp/gunison/left/file1\t2021-02-13 14:29:12.571303322 +0300\n@@ -1,6 +1,6 @@\n-Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n+Quia est unde laboriosam. Eum ullam deleniti dolorrupti maiores optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n+Consectetur fuga sed vitae eut ut sed officiis id. Et aut nostrum est quia cores. Magni quasi facere voluptas. Dolor doloribus at nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n \n Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspiciatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n \n")})
+		Update{DiffChunk: []byte("--- /home/vasiliy/tmp/gunison/right/file1\t2021-02-13 14:29:12.571303322 +0300\n+++ /home/vasiliy/tmp/gunison/left/file1\t2021-02-13 14:29:12.571303322 +0300\n@@ -1,6 +1,6 @@\n-Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n+Quia est unde laboriosam. Eum ullam deleniti dolorrupti maiores optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n+Consectetur fuga sed vitae eut ut sed officiis id. Et aut nostrum est quia cores. Magni quasi facere voluptas. Dolor doloribus at nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n \n Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspiciatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n \n"), DiffDone: true})
 }
 
 func TestDiffRandom(t *testing.T) {
 	// Like TestDiff, but chunks of Unison output get buffered randomly before arriving to Gunison.
 	c := NewCore()
+	var diff []byte
+	recv := func(output string) Update { // like ProcOutput, but collects DiffChunks into diff
+		upd := c.ProcOutput([]byte(output))
+		diff = append(diff, upd.DiffChunk...)
+		upd.DiffChunk = nil
+		return upd
+	}
 	assert.Zero(t, c.ProcStart())
 	assertEqual(t, c.ProcOutput([]byte("Unison 2.51.3 (ocaml 4.11.1): Contacting server...\nLooking for changes\n/ file2\r       \rReconciling changes\n\nleft           right              \nchanged  ---->            file1  [f] ")),
 		Update{
@@ -374,27 +535,29 @@ func TestDiffRandom(t *testing.T) {
 		Update{Input: []byte("n\n")})
 	assertEqual(t, c.ProcOutput([]byte("changed  ---->            file3  [f] ")),
 		Update{Input: []byte("d\n")})
-	assert.Zero(t, c.ProcOutput([]byte("\ndiff -u '/home/vasiliy/tmp/gunison/right/file3' '/home/vasiliy/tmp/gunison/left/file3'\n\n")))
-	assert.Zero(t, c.ProcOutput([]byte("--- /home/vasiliy/tmp/guniso")))
-	assert.Zero(t, c.ProcOutput([]byte("n/right/file3\t2021-03-22 11:21:26.165184522 +0300\n+++ /home/vasiliy/tmp/gu")))
-	assert.Zero(t, c.ProcOutput([]byte("nison/left/file3\t2021-03-22 11:21:26.165184522 +0300\n@@ -1,9 +1,9 @@\n-Quia est unde laboriosam. Eum ullam delen")))
-	assert.Zero(t, c.ProcOutput([]byte("iti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n+Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed offi")))
-	assert.Zero(t, c.ProcOutput([]byte("ciis id. Et aut nostrum est quia cororiosam at labore omnis eos et minus porro perspiciatis. Veniam in dignissimos voluptatem exercitatio")))
-	assert.Zero(t, c.ProcOutput([]byte("nem excepturi reprehenderit sed optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in volu")))
-	assert.Zero(t, c.ProcOutput([]byte("ptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n+Omnis reput consequatur")))
-	assert.Zero(t, c.ProcOutput([]byte(" neque. Veniam in voluptate quia. Culpa labore distinctio laudanti")))
-	assert.Zero(t, c.ProcOutput([]byte("um maxime voluptate eaque.\n \n-Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspiciatis.")))
-	assert.Zero(t, c.ProcOutput([]byte(" Veniam in dignissimos voluptatem exercitationem excepturi")))
-	assert.Zero(t, c.ProcOutput([]byte(" reprehenderit sed optio.\n+Deserunt dignissimos corrupti aut vel. Labrupti maiores optio.\n \n-Omnis repudiandae nobis autem qui autem possimus. Dolo")))
-	assert.Zero(t, c.ProcOutput([]byte("rem id a reprehenderit nihil laboriosam non. Dolor minima in soluta. Magni eveniet magnam velit officia consectetur tempore quia id. Perspiciatis enim corrupti aliquam nam accusamus et molestiae rerum. Sint sit exercitationem corrupti omnis.\n+Cons")))
-	assert.Zero(t, c.ProcOutput([]byte("ectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium udiandae nobis autem qui autem possimus. Dolorem id a reprehend")))
-	assert.Zero(t, c.ProcOutput([]byte("erit nihil laboriosam non. Dolor minima in soluta. Magni eveniet magnam velit officia co")))
-	assert.Zero(t, c.ProcOutput([]byte("nsectetur tempore quia id. Perspiciatis enim corrupti aliquam nam accusamus et molestiae r")))
-	assert.Zero(t, c.ProcOutput([]byte("erum. Sint sit ")))
-	assert.Zero(t, c.ProcOutput([]byte("exercitationem corrupti omnis.\n \n Facere asperiores unde rerum dignissimos id. Nihil maiores sequi accusamus eum repudiandae et. Nesciunt ab inventore repellat enim illum ratione enim voluptatum. Tempore sint quos tempore fugit rerum sit omn")))
-	assert.Zero(t, c.ProcOutput([]byte("is quae. Minus deserunt aut dolores excepturi qui.\n\nchanged  ---->            ")))
-	assertEqual(t, c.ProcOutput([]byte("file3  [f] ")),
-		Update{Diff: []byte("--- /home/vasiliy/tmp/gunison/right/file3\t2021-03-22 11:21:26.165184522 +0300\n+++ /home/vasiliy/tmp/gunison/left/file3\t2021-03-22 11:21:26.165184522 +0300\n@@ -1,9 +1,9 @@\n-Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n+Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia cororiosam at labore omnis eos et minus porro perspiciatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n+Omnis reput consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n \n-Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspiciatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n+Deserunt dignissimos corrupti aut vel. Labrupti maiores optio.\n \n-Omnis repudiandae nobis autem qui autem possimus. Dolorem id a reprehenderit nihil laboriosam non. Dolor minima in soluta. Magni eveniet magnam velit officia consectetur tempore quia id. Perspiciatis enim corrupti aliquam nam accusamus et molestiae rerum. Sint sit exercitationem corrupti omnis.\n+Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium udiandae nobis autem qui autem possimus. Dolorem id a reprehenderit nihil laboriosam non. Dolor minima in soluta. Magni eveniet magnam velit officia consectetur tempore quia id. Perspiciatis enim corrupti aliquam nam accusamus et molestiae rerum. Sint sit exercitationem corrupti omnis.\n \n Facere asperiores unde rerum dignissimos id. Nihil maiores sequi accusamus eum repudiandae et. Nesciunt ab inventore repellat enim illum ratione enim voluptatum. Tempore sint quos tempore fugit rerum sit omnis quae. Minus deserunt aut dolores excepturi qui.\n")})
+	assert.Zero(t, recv("\ndiff -u '/home/vasiliy/tmp/gunison/right/file3' '/home/vasiliy/tmp/gunison/left/file3'\n\n"))
+	assert.Zero(t, recv("--- /home/vasiliy/tmp/guniso"))
+	assert.Zero(t, recv("n/right/file3\t2021-03-22 11:21:26.165184522 +0300\n+++ /home/vasiliy/tmp/gu"))
+	assert.Zero(t, recv("nison/left/file3\t2021-03-22 11:21:26.165184522 +0300\n@@ -1,9 +1,9 @@\n-Quia est unde laboriosam. Eum ullam delen"))
+	assert.Zero(t, recv("iti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n+Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed offi"))
+	assert.Zero(t, recv("ciis id. Et aut nostrum est quia cororiosam at labore omnis eos et minus porro perspiciatis. Veniam in dignissimos voluptatem exercitatio"))
+	assert.Zero(t, recv("nem excepturi reprehenderit sed optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in volu"))
+	assert.Zero(t, recv("ptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n+Omnis reput consequatur"))
+	assert.Zero(t, recv(" neque. Veniam in voluptate quia. Culpa labore distinctio laudanti"))
+	assert.Zero(t, recv("um maxime voluptate eaque.\n \n-Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspiciatis."))
+	assert.Zero(t, recv(" Veniam in dignissimos voluptatem exercitationem excepturi"))
+	assert.Zero(t, recv(" reprehenderit sed optio.\n+Deserunt dignissimos corrupti aut vel. Labrupti maiores optio.\n \n-Omnis repudiandae nobis autem qui autem possimus. Dolo"))
+	assert.Zero(t, recv("rem id a reprehenderit nihil laboriosam non. Dolor minima in soluta. Magni eveniet magnam velit officia consectetur tempore quia id. Perspiciatis enim corrupti aliquam nam accusamus et molestiae rerum. Sint sit exercitationem corrupti omnis.\n+Cons"))
+	assert.Zero(t, recv("ectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium udiandae nobis autem qui autem possimus. Dolorem id a reprehend"))
+	assert.Zero(t, recv("erit nihil laboriosam non. Dolor minima in soluta. Magni eveniet magnam velit officia co"))
+	assert.Zero(t, recv("nsectetur tempore quia id. Perspiciatis enim corrupti aliquam nam accusamus et molestiae r"))
+	assert.Zero(t, recv("erum. Sint sit "))
+	assert.Zero(t, recv("exercitationem corrupti omnis.\n \n Facere asperiores unde rerum dignissimos id. Nihil maiores sequi accusamus eum repudiandae et. Nesciunt ab inventore repellat enim illum ratione enim voluptatum. Tempore sint quos tempore fugit rerum sit omn"))
+	assert.Zero(t, recv("is quae. Minus deserunt aut dolores excepturi qui.\n\nchanged  ---->            "))
+	assertEqual(t, recv("file3  [f] "),
+		Update{DiffDone: true})
+	assertEqual(t, string(diff), "--- /home/vasiliy/tmp/gunison/right/file3\t2021-03-22 11:21:26.165184522 +0300\n+++ /home/vasiliy/tmp/gunison/left/file3\t2021-03-22 11:21:26.165184522 +0300\n@@ -1,9 +1,9 @@\n-Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n+Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia cororiosam at labore omnis eos et minus porro perspiciatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n+Omnis reput consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n \n-Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspiciatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n+Deserunt dignissimos corrupti aut vel. Labrupti maiores optio.\n \n-Omnis repudiandae nobis autem qui autem possimus. Dolorem id a reprehenderit nihil laboriosam non. Dolor minima in soluta. Magni eveniet magnam velit officia consectetur tempore quia id. Perspiciatis enim corrupti aliquam nam accusamus et molestiae rerum. Sint sit exercitationem corrupti omnis.\n+Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium udiandae nobis autem qui autem possimus. Dolorem id a reprehenderit nihil laboriosam non. Dolor minima in soluta. Magni eveniet magnam velit officia consectetur tempore quia id. Perspiciatis enim corrupti aliquam nam accusamus et molestiae rerum. Sint sit exercitationem corrupti omnis.\n \n Facere asperiores unde rerum dignissimos id. Nihil maiores sequi accusamus eum repudiandae et. Nesciunt ab inventore repellat enim illum ratione enim voluptatum. Tempore sint quos tempore fugit rerum sit omnis quae. Minus deserunt aut dolores excepturi qui.\n")
+	diff = nil
 
 	assertEqual(t, c.Diff("file2"),
 		Update{Input: []byte("0\n")})
@@ -402,34 +565,37 @@ func TestDiffRandom(t *testing.T) {
 		Update{Input: []byte("n\n")})
 	assertEqual(t, c.ProcOutput([]byte("changed  ---->            file2  [f] ")),
 		Update{Input: []byte("d\n")})
-	assert.Zero(t, c.ProcOutput([]byte("\ndiff -u '/home/vasiliy/tmp/gunison/right/file2' '/home/vasiliy/tmp/gunison/left/file2'\n\n--- /home/vasiliy/tmp/gunison/right/file")))
-	assert.Zero(t, c.ProcOutput([]byte("2\t2021-03-22 11:21:26.165184522 +0300\n+++ /home/vasiliy/")))
-	assert.Zero(t, c.ProcOutput([]byte("tmp/gunison/left/file2\t2021-03-22 11:21:26.165184522 +0300\n@@ -1,6 +1,6 @@\n-Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maior")))
-	assert.Zero(t, c.ProcOutput([]byte("es optio.\n+Quia est unde labori")))
-	assert.Zero(t, c.ProcOutput([]byte("osam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate t nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut ut")))
-	assert.Zero(t, c.ProcOutput([]byte(" sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet rerum o")))
-	assert.Zero(t, c.ProcOutput([]byte("fficia repudi")))
-	assert.Zero(t, c.ProcOutput([]byte("andae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n+Consectetur fuga sed vit")))
-	assert.Zero(t, c.ProcOutput([]byte("ae eeaque.\n \n Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus p")))
-	assert.Zero(t, c.ProcOutput([]byte("orro perspiciatis. Veniam in dignissimos voluptatem exercitatio")))
-	assertEqual(t, c.ProcOutput([]byte("nem excepturi reprehenderit sed optio.\n \n\nchanged  ---->            file2  [f] ")),
-		Update{Diff: []byte("--- /home/vasiliy/tmp/gunison/right/file2\t2021-03-22 11:21:26.165184522 +0300\n+++ /home/vasiliy/tmp/gunison/left/file2\t2021-03-22 11:21:26.165184522 +0300\n@@ -1,6 +1,6 @@\n-Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n+Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate t nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n+Consectetur fuga sed vitae eeaque.\n \n Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspiciatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n \n")})
+	assert.Zero(t, recv("\ndiff -u '/home/vasiliy/tmp/gunison/right/file2' '/home/vasiliy/tmp/gunison/left/file2'\n\n--- /home/vasiliy/tmp/gunison/right/file"))
+	assert.Zero(t, recv("2\t2021-03-22 11:21:26.165184522 +0300\n+++ /home/vasiliy/"))
+	assert.Zero(t, recv("tmp/gunison/left/file2\t2021-03-22 11:21:26.165184522 +0300\n@@ -1,6 +1,6 @@\n-Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maior"))
+	assert.Zero(t, recv("es optio.\n+Quia est unde labori"))
+	assert.Zero(t, recv("osam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate t nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut ut"))
+	assert.Zero(t, recv(" sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet rerum o"))
+	assert.Zero(t, recv("fficia repudi"))
+	assert.Zero(t, recv("andae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n+Consectetur fuga sed vit"))
+	assert.Zero(t, recv("ae eeaque.\n \n Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus p"))
+	assert.Zero(t, recv("orro perspiciatis. Veniam in dignissimos voluptatem exercitatio"))
+	assertEqual(t, recv("nem excepturi reprehenderit sed optio.\n \n\nchanged  ---->            file2  [f] "),
+		Update{DiffDone: true})
+	assertEqual(t, string(diff), "--- /home/vasiliy/tmp/gunison/right/file2\t2021-03-22 11:21:26.165184522 +0300\n+++ /home/vasiliy/tmp/gunison/left/file2\t2021-03-22 11:21:26.165184522 +0300\n@@ -1,6 +1,6 @@\n-Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n+Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate t nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n+Consectetur fuga sed vitae eeaque.\n \n Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspiciatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n \n")
+	diff = nil
 
 	assertEqual(t, c.Diff("file1"),
 		Update{Input: []byte("0\n")})
 	assertEqual(t, c.ProcOutput([]byte("changed  ---->            file1  [f] ")),
 		Update{Input: []byte("d\n")})
-	assert.Zero(t, c.ProcOutput([]byte("\ndiff -u '/home/vasiliy/tmp/gunison/right/file1' '/home/vasiliy/tmp/gunison/left/file1'\n\n--- /home/vasiliy/tmp/gunison/right/file1\t2021-03-22 11:21:26.165184522 +0300\n+++ /home/v")))
-	assert.Zero(t, c.ProcOutput([]byte("asiliy/tmp/gunison/left/file1\t2021-03-22 11:21:26.165184522 +0300\n@@ -1,6 +1,6 @@\n-Quia est unde laboriosam. Eum ullam delen")))
-	assert.Zero(t, c.ProcOutput([]byte("iti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n+Quia est unde laboriosam. Eum ullam deleniti dolorrupti maiores optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet reru")))
-	assert.Zero(t, c.ProcOutput([]byte("m officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudant")))
-	assert.Zero(t, c.ProcOutput([]byte("ium maxime voluptate eaque.\n+Consectetur fuga sed vitae e")))
-	assert.Zero(t, c.ProcOutput([]byte("ut ut sed officiis id. Et aut nostrum est quia cores. Magni quasi facere voluptas. Dolor doloribus at nihil quia. Eveniet")))
-	assert.Zero(t, c.ProcOutput([]byte(" rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n \n ")))
-	assert.Zero(t, c.ProcOutput([]byte("Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspiciatis. ")))
-	assert.Zero(t, c.ProcOutput([]byte("Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n \n\nchanged  ---->")))
-	assertEqual(t, c.ProcOutput([]byte("            file1  [f] ")),
-		Update{Diff: []byte("--- /home/vasiliy/tmp/gunison/right/file1\t2021-03-22 11:21:26.165184522 +0300\n+++ /home/vasiliy/tmp/gunison/left/file1\t2021-03-22 11:21:26.165184522 +0300\n@@ -1,6 +1,6 @@\n-Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n+Quia est unde laboriosam. Eum ullam deleniti dolorrupti maiores optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n+Consectetur fuga sed vitae eut ut sed officiis id. Et aut nostrum est quia cores. Magni quasi facere voluptas. Dolor doloribus at nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n \n Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspiciatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n \n")})
+	assert.Zero(t, recv("\ndiff -u '/home/vasiliy/tmp/gunison/right/file1' '/home/vasiliy/tmp/gunison/left/file1'\n\n--- /home/vasiliy/tmp/gunison/right/file1\t2021-03-22 11:21:26.165184522 +0300\n+++ /home/v"))
+	assert.Zero(t, recv("asiliy/tmp/gunison/left/file1\t2021-03-22 11:21:26.165184522 +0300\n@@ -1,6 +1,6 @@\n-Quia est unde laboriosam. Eum ullam delen"))
+	assert.Zero(t, recv("iti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n+Quia est unde laboriosam. Eum ullam deleniti dolorrupti maiores optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet reru"))
+	assert.Zero(t, recv("m officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudant"))
+	assert.Zero(t, recv("ium maxime voluptate eaque.\n+Consectetur fuga sed vitae e"))
+	assert.Zero(t, recv("ut ut sed officiis id. Et aut nostrum est quia cores. Magni quasi facere voluptas. Dolor doloribus at nihil quia. Eveniet"))
+	assert.Zero(t, recv(" rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n \n "))
+	assert.Zero(t, recv("Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspiciatis. "))
+	assert.Zero(t, recv("Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n \n\nchanged  ---->"))
+	assertEqual(t, recv("            file1  [f] "),
+		Update{DiffDone: true})
+	assertEqual(t, string(diff), "--- /home/vasiliy/tmp/gunison/right/file1\t2021-03-22 11:21:26.165184522 +0300\n+++ /home/vasiliy/tmp/gunison/left/file1\t2021-03-22 11:21:26.165184522 +0300\n@@ -1,6 +1,6 @@\n-Quia est unde laboriosam. Eum ullam deleniti dolores. Magni quasi facere voluptas. Dolor doloribus aut ut sed officiis id. Et aut nostrum est quia corrupti maiores optio.\n+Quia est unde laboriosam. Eum ullam deleniti dolorrupti maiores optio.\n \n-Consectetur fuga sed vitae et nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n+Consectetur fuga sed vitae eut ut sed officiis id. Et aut nostrum est quia cores. Magni quasi facere voluptas. Dolor doloribus at nihil quia. Eveniet rerum officia repudiandae tenetur molestiae. Magni ipsum et natus accusantium ut consequatur neque. Veniam in voluptate quia. Culpa labore distinctio laudantium maxime voluptate eaque.\n \n Deserunt dignissimos corrupti aut vel. Laboriosam at labore omnis eos et minus porro perspiciatis. Veniam in dignissimos voluptatem exercitationem excepturi reprehenderit sed optio.\n \n")
 }
 
 func TestDiffNoOutput(t *testing.T) {
@@ -444,6 +610,56 @@ func TestDiffNoOutput(t *testing.T) {
 	assertEqual(t, c.Status, "Ready to synchronize")
 }
 
+func TestDiffLarge(t *testing.T) {
+	// A large diff is passed on as it arrives, without accumulating in the buffer.
+	c := initCoreMinimalReady(t)
+	assertEqual(t, c.Diff("one"),
+		Update{Input: []byte("0\n")})
+	assertEqual(t, c.ProcOutput([]byte("changed  ---->            one  [f] ")),
+		Update{Input: []byte("d\n")})
+	assertEqual(t, c.ProcOutput([]byte("\ndiff -u '/home/vasiliy/tmp/gunison/right/one' '/home/vasiliy/tmp/gunison/left/one'\n\n--- /home/vasiliy/tmp/gunison/right/one\n+++ /home/vasiliy/tmp/gunison/left/one\n@@ -1,100000 +1,100000 @@\n")),
+		Update{DiffChunk: []byte("--- /home/vasiliy/tmp/gunison/right/one\n+++ /home/vasiliy/tmp/gunison/left/one\n@@ -1,100000 +1,100000 @@")})
+	var diff []byte
+	for i := 0; i < 100000; i++ {
+		upd := c.ProcOutput([]byte(fmt.Sprintf("-line %d\n+Line %d\n", i, i)))
+		require.NotEmpty(t, upd.DiffChunk)
+		require.Less(t, c.buf.Len(), 10)
+		diff = append(diff, upd.DiffChunk...)
+	}
+	assertEqual(t, c.ProcOutput([]byte("\nchanged  ---->            one  [f] ")),
+		Update{DiffChunk: []byte("\n"), DiffDone: true})
+	assert.Len(t, diff, 2377780)
+	assertEqual(t, c.Status, "Ready to synchronize")
+}
+
+func TestDiffLongLine(t *testing.T) {
+	// A diff of a file without newlines doesn't accumulate in the buffer, either.
+	c := initCoreMinimalReady(t)
+	assertEqual(t, c.Diff("one"),
+		Update{Input: []byte("0\n")})
+	assertEqual(t, c.ProcOutput([]byte("changed  ---->            one  [f] ")),
+		Update{Input: []byte("d\n")})
+	assertEqual(t, c.ProcOutput([]byte("\ndiff -u '/home/vasiliy/tmp/gunison/right/one' '/home/vasiliy/tmp/gunison/left/one'\n\n--- /home/vasiliy/tmp/gunison/right/one\n+++ /home/vasiliy/tmp/gunison/left/one\n@@ -1 +1 @@\n-{}\n+")),
+		Update{DiffChunk: []byte("--- /home/vasiliy/tmp/gunison/right/one\n+++ /home/vasiliy/tmp/gunison/left/one\n@@ -1 +1 @@\n-{}")})
+	var diff []byte
+	for i := 0; i < 100000; i++ {
+		upd := c.ProcOutput([]byte(`{"key": "value"},`))
+		diff = append(diff, upd.DiffChunk...)
+		require.Less(t, c.buf.Len(), 200)
+	}
+	// Even if the line ends like a prompt, it is still the same line, not a prompt.
+	upd := c.ProcOutput([]byte("changed  ---->            one  [f] "))
+	diff = append(diff, upd.DiffChunk...)
+	assert.False(t, upd.DiffDone)
+	assertEqual(t, c.Status, "Requesting diff")
+	upd = c.ProcOutput([]byte("\n\nchanged  ---->            one  [f] "))
+	diff = append(diff, upd.DiffChunk...)
+	assert.True(t, upd.DiffDone)
+	assertEqual(t, string(diff),
+		"\n+"+strings.Repeat(`{"key": "value"},`, 100000)+"changed  ---->            one  [f] \n")
+	assertEqual(t, c.Status, "Ready to synchronize")
+}
+
 func TestDiffDirectory(t *testing.T) {
 	c := NewCore()
 	assert.Zero(t, c.ProcStart())
@@ -539,6 +755,179 @@ func TestDiffBadItem(t *testing.T) {
 		})
 }
 
+func TestDiffMultiple(t *testing.T) {
+	c := NewCore()
+	assert.Zero(t, c.ProcStart())
+	assert.Zero(t, c.ProcOutput([]byte("\nleft           right              \n")))
+	assertEqual(t, c.ProcOutput([]byte("changed  ---->            file1  [f] ")),
+		Update{Input: []byte("l\n")})
+	assert.Zero(t, c.ProcOutput([]byte("  ")))
+	assert.Zero(t, c.ProcOutput([]byte("changed  ---->            file1  \n")))
+	assert.Zero(t, c.ProcOutput([]byte("left         : changed file       modified on 2021-02-13 at 14:29:12  size 1146      rw-r--r--\nright        : unchanged file     modified on 2021-02-13 at 14:29:12  size 1146      rw-r--r--\n  ")))
+	assert.Zero(t, c.ProcOutput([]byte("changed  ---->            file2  \n")))
+	assert.Zero(t, c.ProcOutput([]byte("left         : changed file       modified on 2021-02-13 at 14:29:12  size 1146      rw-r--r--\nright        : unchanged file     modified on 2021-02-13 at 14:29:12  size 1146      rw-r--r--\n  ")))
+	assert.Zero(t, c.ProcOutput([]byte("changed  ---->            file3  \n")))
+	assert.Zero(t, c.ProcOutput([]byte("left         : changed file       modified on 2021-02-13 at 14:29:12  size 1146      rw-r--r--\nright        : unchanged file     modified on 2021-02-13 at 14:29:12  size 1146      rw-r--r--\n  ")))
+	assert.Zero(t, c.ProcOutput([]byte("changed  ---->            file1  [f] ")))
+
+	// Paths are diffed in the order of Unison's prompts, regardless of the order requested.
+	assertEqual(t, c.Diff("file3", "file1", "file3"),
+		Update{Input: []byte("0\n")})
+	assertEqual(t, c.Status, "Requesting diff")
+	assertEqual(t, c.ProcOutput([]byte("changed  ---->            file1  [f] ")),
+		Update{Input: []byte("d\n")})
+	assertEqual(t, c.ProcOutput([]byte("\ndiff -u '/home/vasiliy/tmp/gunison/right/file1' '/home/vasiliy/tmp/gunison/left/file1'\n\n--- /home/vasiliy/tmp/gunison/right/file1\n+++ /home/vasiliy/tmp/gunison/left/file1\n@@ -1 +1 @@\n-one\n+eins\n\nchanged  ---->            file1  [f] ")),
+		Update{
+			Input:     []byte("n\n"),
+			DiffChunk: []byte("--- /home/vasiliy/tmp/gunison/right/file1\n+++ /home/vasiliy/tmp/gunison/left/file1\n@@ -1 +1 @@\n-one\n+eins\n"),
+		})
+	assertEqual(t, c.Status, "Requesting diff")
+	assert.True(t, c.Busy)
+	assertEqual(t, c.ProcOutput([]byte("changed  ---->            file2  [f] ")),
+		Update{Input: []byte("n\n")})
+	assertEqual(t, c.ProcOutput([]byte("changed  ---->            file3  [f] ")),
+		Update{Input: []byte("d\n")})
+	assertEqual(t, c.ProcOutput([]byte("\ndiff -u '/home/vasiliy/tmp/gunison/right/file3' '/home/vasiliy/tmp/gunison/left/file3'\n\n--- /home/vasiliy/tmp/gunison/right/file3\n+++ /home/vasiliy/tmp/gunison/left/file3\n@@ -1 +1 @@\n-three\n+drei\n\nchanged  ---->            file3  [f] ")),
+		Update{DiffChunk: []byte("--- /home/vasiliy/tmp/gunison/right/file3\n+++ /home/vasiliy/tmp/gunison/left/file3\n@@ -1 +1 @@\n-three\n+drei\n"), DiffDone: true})
+	assertEqual(t, c.Status, "Ready to synchronize")
+	assert.False(t, c.Busy)
+	assert.NotNil(t, c.Diff)
+
+	// The UI may sort Items (here, by path descending), but Unison's prompts stay in the same order.
+	c.Items[0], c.Items[2] = c.Items[2], c.Items[0]
+
+	// An error with one of the items doesn't prevent diffing the rest.
+	assertEqual(t, c.Diff("file2", "file3"),
+		Update{Input: []byte("0\n")})
+	assertEqual(t, c.ProcOutput([]byte("changed  ---->            file1  [f] ")),
+		Update{Input: []byte("n\n")})
+	assertEqual(t, c.ProcOutput([]byte("changed  ---->            file2  [f] ")),
+		Update{Input: []byte("d\n")})
+	assertEqual(t, c.ProcOutput([]byte("Can't diff: path doesn't refer to a file in both replicas\nchanged  ---->            file2  [f] ")),
+		Update{
+			Input: []byte("n\n"),
+			Messages: []Message{
+				{"Can't diff: path doesn't refer to a file in both replicas", Error},
+			},
+		})
+	assertEqual(t, c.ProcOutput([]byte("changed  ---->            file3  [f] ")),
+		Update{Input: []byte("d\n")})
+	// While seeking to the next item, the whole thing can be aborted.
+	assertEqual(t, c.Abort(),
+		Update{Interrupt: true})
+}
+
+func TestIgnore(t *testing.T) {
+	c := NewCore()
+	assert.Zero(t, c.ProcStart())
+	assert.Zero(t, c.ProcOutput([]byte("\nleft           right              \n")))
+	assertEqual(t, c.ProcOutput([]byte("changed  ---->            file1  [f] ")),
+		Update{Input: []byte("l\n")})
+	assert.Zero(t, c.ProcOutput([]byte("  ")))
+	assert.Zero(t, c.ProcOutput([]byte("changed  ---->            file1  \n")))
+	assert.Zero(t, c.ProcOutput([]byte("left         : changed file       modified on 2021-02-13 at 15:12:12  size 1146      rw-r--r--\nright        : unchanged file     modified on 2021-02-13 at 15:12:12  size 1146      rw-r--r--\n  ")))
+	assert.Zero(t, c.ProcOutput([]byte("changed  ---->            dir/file2.txt  \n")))
+	assert.Zero(t, c.ProcOutput([]byte("left         : changed file       modified on 2021-02-13 at 15:12:12  size 1146      rw-r--r--\nright        : unchanged file     modified on 2021-02-13 at 15:12:12  size 1146      rw-r--r--\n  ")))
+	assert.Zero(t, c.ProcOutput([]byte("changed  ---->            file3.txt  \n")))
+	assert.Zero(t, c.ProcOutput([]byte("left         : changed file       modified on 2021-02-13 at 15:12:12  size 1146      rw-r--r--\nright        : unchanged file     modified on 2021-02-13 at 15:12:12  size 1146      rw-r--r--\n  ")))
+	assert.Zero(t, c.ProcOutput([]byte("changed  ---->            file1  [f] ")))
+
+	assertEqual(t, c.Ignore("file3.txt", IgnoreExt),
+		Update{Input: []byte("0\n")})
+	assertEqual(t, c.Status, "Adding ignore rule")
+	assert.True(t, c.Busy)
+	assert.Nil(t, c.Sync)
+	assert.Nil(t, c.Ignore)
+	assertEqual(t, c.ProcOutput([]byte("changed  ---->            file1  [f] ")),
+		Update{Input: []byte("n\n")})
+	assertEqual(t, c.ProcOutput([]byte("changed  ---->            dir/file2.txt  [f] ")),
+		Update{Input: []byte("n\n")})
+	assertEqual(t, c.ProcOutput([]byte("changed  ---->            file3.txt  [f] ")),
+		Update{Input: []byte("E\n")})
+	assert.Nil(t, c.Abort)
+	assert.Zero(t, c.ProcOutput([]byte("\n    Permanently ignoring files with this extension\n")))
+	assertEqual(t, c.ProcOutput([]byte("Proceed with propagating updates? [] ")),
+		Update{Replanned: true})
+	assertEqual(t, c.Status, "Ready to synchronize")
+	assert.False(t, c.Busy)
+	require.Len(t, c.Items, 1)
+	assertEqual(t, c.Items[0].Path, "file1")
+
+	// Unison may or may not prompt for the items that it now ignores.
+	assertEqual(t, c.Sync(),
+		Update{Input: []byte("0\n")})
+	assertEqual(t, c.ProcOutput([]byte("changed  ---->            file1  [f] ")),
+		Update{Input: []byte(">\n")})
+	assertEqual(t, c.ProcOutput([]byte("changed  ---->            dir/file2.txt  [f] ")),
+		Update{Input: []byte("/\n")})
+	assertEqual(t, c.ProcOutput([]byte("Proceed with propagating updates? [] ")),
+		Update{Input: []byte("y\n")})
+}
+
+func TestIgnoreMatcher(t *testing.T) {
+	cases := []struct {
+		name    string
+		path    string
+		what    Ignore
+		matches []string
+		others  []string
+	}{
+		{
+			name:    lineno(),
+			path:    "foo/bar",
+			what:    IgnorePath,
+			matches: []string{"foo/bar", "foo/bar/baz"},
+			others:  []string{"foo", "foo/barbaz", "bar", "qux/foo/bar"},
+		},
+		{
+			name:    lineno(),
+			path:    "",
+			what:    IgnorePath,
+			matches: []string{"", "foo", "foo/bar"},
+		},
+		{
+			name:    lineno(),
+			path:    "foo/bar",
+			what:    IgnoreName,
+			matches: []string{"bar", "foo/bar", "qux/bar/baz"},
+			others:  []string{"foo", "barbaz", "foo/bar.txt"},
+		},
+		{
+			name:    lineno(),
+			path:    "foo/bar.txt",
+			what:    IgnoreName,
+			matches: []string{"bar.txt", "qux/bar.txt"},
+			others:  []string{"bar", "baz.txt"},
+		},
+		{
+			name:    lineno(),
+			path:    "foo/bar.txt",
+			what:    IgnoreExt,
+			matches: []string{"bar.txt", "baz.txt", ".txt", "qux/x.y.txt", "qux.txt/foo"},
+			others:  []string{"bar", "foo/txt", "bar.txt.bak"},
+		},
+		{
+			name:    lineno(),
+			path:    "foo/bar", // no extension, so Unison ignores the name instead
+			what:    IgnoreExt,
+			matches: []string{"bar", "qux/bar"},
+			others:  []string{"bar.txt", "baz"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			matches := ignoreMatcher(c.path, c.what)
+			for _, p := range c.matches {
+				assert.True(t, matches(p), p)
+			}
+			for _, p := range c.others {
+				assert.False(t, matches(p), p)
+			}
+		})
+	}
+}
+
 func TestMerge(t *testing.T) {
 	c := initCoreMinimalReady(t)
 	c.Items[0].Override = Merge
@@ -680,8 +1069,8 @@ func TestReplicaMissing(t *testing.T) {
 	assertEqual(t, c.Items, []Item{
 		{
 			Path:           "",
-			Left:           Content{Directory, Unchanged, "modified on 2021-02-06 at 18:31:42  size 1146      rwxr-xr-x"},
-			Right:          Content{Absent, Deleted, ""},
+			Left:           content(Directory, Unchanged, "modified on 2021-02-06 at 18:31:42  size 1146      rwxr-xr-x"),
+			Right:          content(Absent, Deleted, ""),
 			Recommendation: RightToLeft,
 		},
 	})
@@ -805,104 +1194,104 @@ func TestAssorted(t *testing.T) {
 	assertEqual(t, c.Items, []Item{
 		{
 			Path:           "one hundred/one hundred one",
-			Left:           Content{File, Modified, "modified on 2021-02-06 at 18:41:58  size 1000      rw-r--r--"},
-			Right:          Content{Directory, Created, "modified on 2021-02-06 at 18:41:58  size 2292      rwxr-xr-x"},
+			Left:           content(File, Modified, "modified on 2021-02-06 at 18:41:58  size 1000      rw-r--r--"),
+			Right:          content(Directory, Created, "modified on 2021-02-06 at 18:41:58  size 2292      rwxr-xr-x"),
 			Recommendation: Skip,
 		},
 		{
 			Path:           "one hundred/one hundred two",
-			Left:           Content{File, Created, "modified on 2021-02-06 at 18:41:58  size 1146      rw-r--r--"},
-			Right:          Content{Absent, Deleted, ""},
+			Left:           content(File, Created, "modified on 2021-02-06 at 18:41:58  size 1146      rw-r--r--"),
+			Right:          content(Absent, Deleted, ""),
 			Recommendation: Skip,
 		},
 		{
 			Path:           "six/nine",
-			Left:           Content{File, Modified, "modified on 2021-02-06 at 18:41:58  size 1147000   rw-r--r--"},
-			Right:          Content{File, Modified, "modified on 2021-02-06 at 18:41:58  size 1147000   rw-rw-r--"},
+			Left:           content(File, Modified, "modified on 2021-02-06 at 18:41:58  size 1147000   rw-r--r--"),
+			Right:          content(File, Modified, "modified on 2021-02-06 at 18:41:58  size 1147000   rw-rw-r--"),
 			Recommendation: Skip,
 		},
 		{
 			Path:           "twenty one",
-			Left:           Content{File, PropsChanged, "modified on 2021-02-06 at 18:41:58  size 1146      rw-r--r--"},
-			Right:          Content{File, PropsChanged, "modified on 2021-02-06 at 18:41:58  size 1146      rw-rw-r--"},
+			Left:           content(File, PropsChanged, "modified on 2021-02-06 at 18:41:58  size 1146      rw-r--r--"),
+			Right:          content(File, PropsChanged, "modified on 2021-02-06 at 18:41:58  size 1146      rw-rw-r--"),
 			Recommendation: Skip,
 		},
 		{
 			Path:           "deeply/nested/sub/directory/with/file",
-			Left:           Content{File, Unchanged, "modified on 2021-02-06 at 18:41:58  size 1146      rw-r--r--"},
-			Right:          Content{File, Modified, "modified on 2021-02-06 at 18:41:58  size 1146      rw-rw-r--"},
+			Left:           content(File, Unchanged, "modified on 2021-02-06 at 18:41:58  size 1146      rw-r--r--"),
+			Right:          content(File, Modified, "modified on 2021-02-06 at 18:41:58  size 1146      rw-rw-r--"),
 			Recommendation: RightToLeft,
 		},
 		{
 			Path:           "eighteen",
-			Left:           Content{Symlink, Created, "modified on 1970-01-01 at  3:00:00  size 0         unknown permissions"},
+			Left:           content(Symlink, Created, "modified on 1970-01-01 at  3:00:00  size 0         unknown permissions"),
 			Right:          Content{Type: Absent},
 			Recommendation: LeftToRight,
 		},
 		{
 			Path:           "here is a rather long and funny file name, 社會科學院語學研究所\t\v\f \u0085 \u1680\u2002\u2003\u2002\u2003\u2004\u2005\u2006\u2007\u2008\u2009\u200a\u200b\u2028\u2029\u202f\u205f\u3000ﾟ･✿ヾ╲(｡◕‿◕｡)╱✿･ﾟ/here is a rather long and funny file name, 社會科學院語學研究所\t\v\f \u0085 \u1680\u2002\u2003\u2002\u2003\u2004\u2005\u2006\u2007\u2008\u2009\u200a\u200b\u2028\u2029\u202f\u205f\u3000ﾟ･✿ヾ╲(｡◕‿◕｡)╱✿･ﾟ",
-			Left:           Content{File, Unchanged, "modified on 2021-02-06 at 18:41:58  size 1146      rw-r--r--"},
-			Right:          Content{File, Modified, "modified on 2021-02-06 at 18:41:58  size 1146      rw-rw-r--"},
+			Left:           content(File, Unchanged, "modified on 2021-02-06 at 18:41:58  size 1146      rw-r--r--"),
+			Right:          content(File, Modified, "modified on 2021-02-06 at 18:41:58  size 1146      rw-rw-r--"),
 			Recommendation: RightToLeft,
 		},
 		{
 			Path:           "seventeen",
-			Left:           Content{File, Created, "modified on 2021-02-06 at 18:41:58  size 0         rw-r--r--"},
+			Left:           content(File, Created, "modified on 2021-02-06 at 18:41:58  size 0         rw-r--r--"),
 			Right:          Content{Type: Absent},
 			Recommendation: LeftToRight,
 		},
 		{
 			Path:           "six/eight",
-			Left:           Content{File, Unchanged, "modified on 2021-02-06 at 18:41:58  size 1146      rw-r--r--"},
-			Right:          Content{File, Modified, "modified on 2021-02-06 at 18:41:58  size 1147000   rw-rw-r--"},
+			Left:           content(File, Unchanged, "modified on 2021-02-06 at 18:41:58  size 1146      rw-r--r--"),
+			Right:          content(File, Modified, "modified on 2021-02-06 at 18:41:58  size 1147000   rw-rw-r--"),
 			Recommendation: RightToLeft,
 		},
 		{
 			Path:           "six/eleven",
-			Left:           Content{File, Unchanged, "modified on 2021-02-06 at 18:41:58  size 10000000  rw-r--r--"},
-			Right:          Content{File, Modified, "modified on 2021-02-06 at 18:41:58  size 10000000  rw-rw-r--"},
+			Left:           content(File, Unchanged, "modified on 2021-02-06 at 18:41:58  size 10000000  rw-r--r--"),
+			Right:          content(File, Modified, "modified on 2021-02-06 at 18:41:58  size 10000000  rw-rw-r--"),
 			Recommendation: RightToLeft,
 		},
 		{
 			Path:           "six/fourteen",
-			Left:           Content{Directory, Unchanged, "modified on 2021-02-06 at 18:41:58  size 2292      rwxr-xr-x"},
+			Left:           content(Directory, Unchanged, "modified on 2021-02-06 at 18:41:58  size 2292      rwxr-xr-x"),
 			Right:          Content{Type: Absent, Status: Deleted},
 			Recommendation: RightToLeft,
 		},
 		{
 			Path:           "six/seven",
-			Left:           Content{File, Unchanged, "modified on 2021-02-06 at 18:41:58  size 0         rw-r--r--"},
-			Right:          Content{File, Modified, "modified on 2021-02-06 at 18:41:58  size 1146      rw-rw-r--"},
+			Left:           content(File, Unchanged, "modified on 2021-02-06 at 18:41:58  size 0         rw-r--r--"),
+			Right:          content(File, Modified, "modified on 2021-02-06 at 18:41:58  size 1146      rw-rw-r--"),
 			Recommendation: RightToLeft,
 		},
 		{
 			Path:           "six/ten",
-			Left:           Content{File, PropsChanged, "modified on 2021-02-06 at 18:41:58  size 1000      rwx------"},
-			Right:          Content{File, Unchanged, "modified on 2021-02-06 at 18:41:58  size 1000      rw-r--r--"},
+			Left:           content(File, PropsChanged, "modified on 2021-02-06 at 18:41:58  size 1000      rwx------"),
+			Right:          content(File, Unchanged, "modified on 2021-02-06 at 18:41:58  size 1000      rw-r--r--"),
 			Recommendation: LeftToRight,
 		},
 		{
 			Path:           "three",
-			Left:           Content{File, Unchanged, "modified on 2021-02-06 at 18:41:58  size 1147000   rw-r--r--"},
+			Left:           content(File, Unchanged, "modified on 2021-02-06 at 18:41:58  size 1147000   rw-r--r--"),
 			Right:          Content{Type: Absent, Status: Deleted},
 			Recommendation: RightToLeft,
 		},
 		{
 			Path:           "twelve",
-			Left:           Content{Directory, Unchanged, "modified on 2021-02-06 at 18:41:58  size 0         rwxr-xr-x"},
-			Right:          Content{Directory, PropsChanged, "modified on 2021-02-06 at 18:41:58  size 0         rwx------"},
+			Left:           content(Directory, Unchanged, "modified on 2021-02-06 at 18:41:58  size 0         rwxr-xr-x"),
+			Right:          content(Directory, PropsChanged, "modified on 2021-02-06 at 18:41:58  size 0         rwx------"),
 			Recommendation: RightToLeft,
 		},
 		{
 			Path:           "twenty",
 			Left:           Content{Type: Absent},
-			Right:          Content{Directory, Created, "modified on 2021-02-06 at 18:41:58  size 0         rwxr-xr-x"},
+			Right:          content(Directory, Created, "modified on 2021-02-06 at 18:41:58  size 0         rwxr-xr-x"),
 			Recommendation: RightToLeft,
 		},
 		{
 			Path:           "two",
-			Left:           Content{File, Modified, "modified on 2021-02-06 at 18:41:58  size 1146      rw-r--r--"},
-			Right:          Content{File, Unchanged, "modified on 2021-02-06 at 18:41:58  size 1146      rw-r--r--"},
+			Left:           content(File, Modified, "modified on 2021-02-06 at 18:41:58  size 1146      rw-r--r--"),
+			Right:          content(File, Unchanged, "modified on 2021-02-06 at 18:41:58  size 1146      rw-r--r--"),
 			Recommendation: LeftToRight,
 		},
 	})
@@ -1087,8 +1476,15 @@ func TestAssorted(t *testing.T) {
 		Update{Messages: []Message{
 			{"failed: two", Error},
 		}})
+	assertEqual(t, resultOf(c, "one hundred/one hundred one"), Result{Outcome: Done})
+	assertEqual(t, resultOf(c, "one hundred/one hundred two"), Result{Skipped, "conflicting updates"})
+	assertEqual(t, resultOf(c, "twenty one"), Result{Outcome: Done})
+	assertEqual(t, resultOf(c, "six/fourteen"), Result{Outcome: Done})
+	assertEqual(t, resultOf(c, "twelve"), Result{Skipped, "skip requested"})
+	assertEqual(t, resultOf(c, "two"), Result{Failed, "'merge' preference not set for two"})
 	assert.Zero(t, c.ProcExit(2, nil))
 	assertEqual(t, c.Status, "Finished with errors")
+	assertEqual(t, c.RetryPaths, []string{"two"})
 }
 
 func TestAssortedRandom(t *testing.T) {
@@ -1246,6 +1642,84 @@ func TestAssortedRandom(t *testing.T) {
 	assertEqual(t, c.Status, "Finished with errors")
 }
 
+func TestProblem(t *testing.T) {
+	c := NewCore()
+	assert.Zero(t, c.ProcStart())
+	assert.Zero(t, c.ProcOutput([]byte("\nleft           right              \n")))
+	assertEqual(t, c.ProcOutput([]byte("changed  ---->            one  [f] ")),
+		Update{Input: []byte("l\n")})
+	assert.Zero(t, c.ProcOutput([]byte("  ")))
+	assert.Zero(t, c.ProcOutput([]byte("changed  ---->            one  \n")))
+	assert.Zero(t, c.ProcOutput([]byte("left         : changed file       modified on 2021-02-07 at  1:50:31  size 1146      rw-r--r--\nright        : unchanged file     modified on 2021-02-07 at  1:50:31  size 1146      rw-r--r--\n  ")))
+	assert.Zero(t, c.ProcOutput([]byte("         error            two  \n")))
+	assert.Zero(t, c.ProcOutput([]byte("Error in reading file /home/vasiliy/tmp/gunison/left/two:\nPermission denied\n")))
+	assert.Zero(t, c.ProcOutput([]byte("changed  ---->            one  [f] ")))
+	assertEqual(t, c.Status, "Ready to synchronize")
+	assertEqual(t, c.Items, []Item{
+		{
+			Path:           "one",
+			Left:           content(File, Modified, "modified on 2021-02-07 at  1:50:31  size 1146      rw-r--r--"),
+			Right:          content(File, Unchanged, "modified on 2021-02-07 at  1:50:31  size 1146      rw-r--r--"),
+			Recommendation: LeftToRight,
+		},
+		{
+			Path:           "two",
+			Recommendation: Problem,
+			Error:          "Error in reading file /home/vasiliy/tmp/gunison/left/two:\nPermission denied",
+		},
+	})
+
+	assertEqual(t, c.Sync(),
+		Update{Input: []byte("0\n")})
+	assertEqual(t, c.ProcOutput([]byte("changed  ---->            one  [f] ")),
+		Update{Input: []byte(">\n")})
+	assert.Zero(t, c.ProcOutput([]byte("changed  ---->            one  \n")))
+	assertEqual(t, c.ProcOutput([]byte("         error            two  [f] ")),
+		Update{Input: []byte("\n")})
+	assert.Zero(t, c.ProcOutput([]byte("         error            two  \n")))
+	assert.Zero(t, c.ProcOutput([]byte("Error in reading file /home/vasiliy/tmp/gunison/left/two:\nPermission denied\n")))
+	assertEqual(t, c.ProcOutput([]byte("\nProceed with propagating updates? [] ")),
+		Update{Input: []byte("y\n")})
+	assertEqual(t, c.Status, "Starting synchronization")
+	assertEqual(t, c.Items[0].Result, Result{Outcome: Pending})
+	assertEqual(t, c.Items[1].Result,
+		Result{Skipped, "Error in reading file /home/vasiliy/tmp/gunison/left/two:\nPermission denied"})
+}
+
+func TestProblemUnexpectedOutput(t *testing.T) {
+	c := NewCore()
+	assert.Zero(t, c.ProcStart())
+	assert.Zero(t, c.ProcOutput([]byte("\nleft           right              \n")))
+	assertEqual(t, c.ProcOutput([]byte("         error            two  [f] ")),
+		Update{Input: []byte("l\n")})
+	assert.Zero(t, c.ProcOutput([]byte("  ")))
+	assert.Zero(t, c.ProcOutput([]byte("         error            two  \n")))
+	assert.Zero(t, c.ProcOutput([]byte("Error in reading file /home/vasiliy/tmp/gunison/left/two:\nPermission denied\n")))
+	// An error message is expected only once, right after the item.
+	assertEqual(t, c.ProcOutput([]byte("left         : changed file       modified on 2021-02-07 at  1:50:31  size 1146      rw-r--r--\nsomething else\n         error            two  [f] ")),
+		Update{
+			Interrupt: true,
+			Messages: []Message{
+				{"Cannot parse the following output from Unison:\nsomething else\nThis is probably a bug in Gunison. Unison will be stopped now.", Error},
+			},
+		})
+}
+
+func TestRoots(t *testing.T) {
+	c := NewCore()
+	assert.Zero(t, c.ProcStart())
+	assert.Zero(t, c.ProcOutput([]byte("Unison 2.51.3 (ocaml 4.11.1): Contacting server...\n")))
+	assert.Zero(t, c.ProcOutput([]byte("Connected [//aqtau//home/vasiliy/tmp/gunison/left -> //raspberrypi//media/backup/gunison]\n")))
+	assertEqual(t, c.LeftRoot, Root{"aqtau", "/home/vasiliy/tmp/gunison/left"})
+	assertEqual(t, c.RightRoot, Root{"raspberrypi", "/media/backup/gunison"})
+	assert.Zero(t, c.ProcOutput([]byte("Looking for changes\n")))
+	assertEqual(t, c.Status, "Looking for changes")
+	assertEqual(t, c.ProcOutput([]byte("Reconciling changes\n\nleft           right              \nchanged  ---->            file1  [f] ")),
+		Update{Input: []byte("l\n")})
+	assertEqual(t, c.LeftRoot, Root{"aqtau", "/home/vasiliy/tmp/gunison/left"})
+	assertEqual(t, c.RightRoot, Root{"raspberrypi", "/media/backup/gunison"})
+}
+
 func TestInterruptLookingForChanges(t *testing.T) {
 	c := NewCore()
 	assert.Zero(t, c.ProcStart())
@@ -1365,8 +1839,13 @@ func TestModifiedDuringSync(t *testing.T) {
 		Update{Messages: []Message{
 			{"failed: one", Error},
 		}})
+	assertEqual(t, c.Items[0].Result,
+		Result{Failed, "The source file /home/vasiliy/tmp/gunison/left/one has been modified during synchronization.  Transfer aborted."})
+	assertEqual(t, describeResult(c.Items[0].Result), // as shown in the tooltip
+		"failed: The source file /home/vasiliy/tmp/gunison/left/one has been modified during synchronization.  Transfer aborted.")
 	assert.Zero(t, c.ProcExit(2, nil))
 	assertEqual(t, c.Status, "Finished with errors")
+	assertEqual(t, c.RetryPaths, []string{"one"})
 }
 
 func TestConnectionLostDuringSync(t *testing.T) {
@@ -1383,6 +1862,8 @@ func TestConnectionLostDuringSync(t *testing.T) {
 	assertEqual(t, c.Status, "Unison exited")
 	assert.False(t, c.Busy)
 	assert.NotNil(t, c.Items)
+	assertEqual(t, c.Items[0].Result, Result{Outcome: InProgress})
+	assert.Nil(t, c.RetryPaths)
 }
 
 func TestErrorDuringStart(t *testing.T) {
@@ -1520,8 +2001,8 @@ func initCoreMinimalReady(t *testing.T) *Core { //nolint:thelper
 	assertEqual(t, c.Items, []Item{
 		{
 			Path:           "one",
-			Left:           Content{File, Modified, "modified on 2021-02-07 at  1:50:31  size 1146      rw-r--r--"},
-			Right:          Content{File, Unchanged, "modified on 2021-02-07 at  1:50:31  size 1146      rw-r--r--"},
+			Left:           content(File, Modified, "modified on 2021-02-07 at  1:50:31  size 1146      rw-r--r--"),
+			Right:          content(File, Unchanged, "modified on 2021-02-07 at  1:50:31  size 1146      rw-r--r--"),
 			Recommendation: LeftToRight,
 		},
 	})
@@ -1529,6 +2010,7 @@ func initCoreMinimalReady(t *testing.T) *Core { //nolint:thelper
 	require.NotNil(t, c.Diff)
 	require.NotNil(t, c.Sync)
 	require.NotNil(t, c.Quit)
+	require.NotNil(t, c.Rescan)
 	require.Nil(t, c.Abort)
 	require.NotNil(t, c.Interrupt)
 	require.NotNil(t, c.Kill)
@@ -1558,6 +2040,20 @@ func initCoreMinimalSyncing(t *testing.T) *Core { //nolint:thelper
 	return c
 }
 
+func resultOf(c *Core, path string) Result {
+	for _, item := range c.Items {
+		if item.Path == path {
+			return item.Result
+		}
+	}
+	panic("no such item: " + path)
+}
+
+// content makes Content the way Core does when parsing the plan.
+func content(typ Type, status Status, props string) Content {
+	return Content{typ, status, props, ParseProperties(props)}
+}
+
 // assertEqual is assert.Equal with arguments swapped, which makes this particular file much more readable.
 func assertEqual(t *testing.T, actual, expected interface{}, msgAndArgs ...interface{}) bool { //nolint:unparam
 	t.Helper()
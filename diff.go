@@ -0,0 +1,86 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A DiffLine is the kind of a line in a diff, as far as displaying it is concerned.
+type DiffLine byte
+
+const (
+	DiffContext DiffLine = iota // unchanged line inside a hunk, or unrecognized line
+	DiffHeader                  // file names and other metadata outside of hunks
+	DiffHunk                    // hunk header, like "@@ -1,4 +1,5 @@"
+	DiffRemoved
+	DiffAdded
+	DiffNote // "\ No newline at end of file"
+)
+
+var (
+	patHunkHeader       = regexp.MustCompile(`^@@ -\d+(?:,(\d+))? \+\d+(?:,(\d+))? @@`)
+	patNormalHunkHeader = regexp.MustCompile(`^\d+(?:,\d+)?[acd]\d+(?:,\d+)?$`)
+)
+
+// A DiffClassifier determines the kinds of successive lines of a diff. It understands the unified
+// format (diff -u) best, but also recognizes changed lines in the normal format (plain diff).
+// The zero value is ready to use.
+type DiffClassifier struct {
+	// Lines remaining in the current hunk, on either side. Inside a hunk, lines starting with
+	// "---" or "+++" are changes, not file headers.
+	oldLeft, newLeft int
+}
+
+// Classify returns the kind of line, which must not include the line terminator.
+func (dc *DiffClassifier) Classify(line string) DiffLine {
+	if dc.oldLeft > 0 || dc.newLeft > 0 {
+		switch {
+		case strings.HasPrefix(line, "-"):
+			dc.oldLeft--
+			return DiffRemoved
+		case strings.HasPrefix(line, "+"):
+			dc.newLeft--
+			return DiffAdded
+		case strings.HasPrefix(line, `\`):
+			return DiffNote
+		default:
+			dc.oldLeft--
+			dc.newLeft--
+			return DiffContext
+		}
+	}
+
+	if m := patHunkHeader.FindStringSubmatch(line); m != nil {
+		dc.oldLeft, dc.newLeft = hunkLength(m[1]), hunkLength(m[2])
+		return DiffHunk
+	}
+
+	switch {
+	case patNormalHunkHeader.MatchString(line):
+		return DiffHunk
+	case strings.HasPrefix(line, `\`):
+		return DiffNote
+	case strings.HasPrefix(line, "< "):
+		return DiffRemoved
+	case strings.HasPrefix(line, "> "):
+		return DiffAdded
+	case strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "+++ "),
+		strings.HasPrefix(line, "diff "), strings.HasPrefix(line, "index "),
+		strings.HasPrefix(line, "Binary files "), strings.HasPrefix(line, "Only in "):
+		return DiffHeader
+	default:
+		return DiffContext
+	}
+}
+
+func hunkLength(s string) int {
+	if s == "" { // "@@ -3 +3 @@" means one line on each side
+		return 1
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return n
+}
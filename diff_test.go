@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffClassifier(t *testing.T) {
+	cases := []struct {
+		name     string
+		diff     string
+		expected []DiffLine
+	}{
+		{
+			name: lineno(),
+			diff: `--- /home/vasiliy/tmp/gunison/replica1/foo.txt	2021-02-13 19:35:44.118521034 +0300
++++ /home/vasiliy/tmp/gunison/replica2/.unison.foo.txt.e6b4bd1d.unison.tmp	2021-02-13 19:35:44.118521034 +0300
+@@ -1,4 +1,4 @@
+ one
+-two
++zwei
+ three
+ four
+@@ -10 +10 @@
+-ten
++zehn`,
+			expected: []DiffLine{
+				DiffHeader, DiffHeader,
+				DiffHunk, DiffContext, DiffRemoved, DiffAdded, DiffContext, DiffContext,
+				DiffHunk, DiffRemoved, DiffAdded,
+			},
+		},
+		{
+			name: lineno(),
+			diff: `--- a/notes.md
++++ b/notes.md
+@@ -1,3 +1,3 @@
+ Heading
+---- old rule
++++++ new rule
+
+\ No newline at end of file
+@@ -7,0 +8,2 @@
++one
++two
+Binary files a/x.png and b/x.png differ`,
+			expected: []DiffLine{
+				DiffHeader, DiffHeader,
+				DiffHunk, DiffContext, DiffRemoved, DiffAdded, DiffContext, DiffNote,
+				DiffHunk, DiffAdded, DiffAdded,
+				DiffHeader,
+			},
+		},
+		{
+			name: lineno(),
+			diff: `2c2
+< two
+---
+> zwei
+5a6
+> six`,
+			expected: []DiffLine{
+				DiffHunk, DiffRemoved, DiffContext, DiffAdded,
+				DiffHunk, DiffAdded,
+			},
+		},
+		{
+			name:     lineno(),
+			diff:     `Files differ in some way`,
+			expected: []DiffLine{DiffContext},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var dc DiffClassifier
+			actual := []DiffLine{}
+			for _, line := range strings.Split(c.diff, "\n") {
+				actual = append(actual, dc.Classify(line))
+			}
+			assertEqual(t, actual, c.expected)
+		})
+	}
+}
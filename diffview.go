@@ -0,0 +1,527 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// The diff window shows either a unified diff from Unison in one text view, or a side-by-side
+// comparison (made by Gunison itself) in two text views whose lines correspond to each other.
+
+var (
+	diffPath  string     // of the item whose differences are being shown
+	diffText  []byte     // currently displayed, as a unified diff, for saving
+	diffHunks []int      // line numbers of hunk headers (or of changes), ascending
+	diffPanes []diffPane // currently shown, left to right
+	diffFocus int        // index into diffPanes of where the user was last searching or navigating
+)
+
+type diffPane struct {
+	view   *gtk.TextView
+	buffer *gtk.TextBuffer
+}
+
+var diffLineTags = map[DiffLine]string{
+	DiffHeader:  "header",
+	DiffHunk:    "hunk",
+	DiffRemoved: "removed",
+	DiffAdded:   "added",
+	DiffNote:    "note",
+}
+
+func setupDiffTags(buffer *gtk.TextBuffer) {
+	buffer.CreateTag("header", map[string]interface{}{"weight": 700})
+	buffer.CreateTag("hunk", map[string]interface{}{
+		"foreground":           "#3465A4",
+		"paragraph-background": "#E6EEF7",
+	})
+	buffer.CreateTag("removed", map[string]interface{}{"paragraph-background": "#FBE3E4"})
+	buffer.CreateTag("added", map[string]interface{}{"paragraph-background": "#E3F5E1"})
+	buffer.CreateTag("removed-span", map[string]interface{}{"background": "#F4B6B9"})
+	buffer.CreateTag("added-span", map[string]interface{}{"background": "#AEE2A8"})
+	buffer.CreateTag("filler", map[string]interface{}{"paragraph-background": "#EEEEEC"})
+	buffer.CreateTag("note", map[string]interface{}{"foreground": "#888A85"})
+	buffer.CreateTag("first-difference", map[string]interface{}{"weight": 700, "foreground": "#A40000"})
+	buffer.CreateTag("match", map[string]interface{}{"background": "#FCE94F"})
+}
+
+// diffItems shows the differences between the versions of items: by comparing them directly
+// if both replicas are on this machine, or else by asking Unison (and its diff preference).
+// Several items are shown as one unified diff.
+func diffItems(items []*Item) {
+	if len(items) == 1 {
+		diffPath = items[0].Path
+	} else {
+		diffPath = fmt.Sprintf("%d files", len(items))
+	}
+
+	switch {
+	case customDiff:
+	case len(items) == 1:
+		if displayLocally(items[0]) {
+			return
+		}
+	default:
+		var diff []byte
+		ok := true
+		for _, item := range items {
+			var cmp *Comparison
+			var names [2]string
+			if cmp, names, ok = compareLocally(item); !ok {
+				break
+			}
+			diff = append(diff, cmp.Unified(names[0], names[1])...)
+		}
+		if ok {
+			displayDiff(diff)
+			return
+		}
+	}
+
+	paths := make([]string, 0, len(items))
+	for _, item := range items {
+		paths = append(paths, item.Path)
+	}
+	diffReceiving = false // in case the previous diff was aborted midway
+	update(core.Diff(paths...))
+}
+
+// displayLocally shows the differences between item's versions by reading them directly:
+// images side by side, text files as a Comparison, and other files as hex dumps.
+// It returns false if that's impossible, e.g. because one of the replicas is remote.
+func displayLocally(item *Item) bool {
+	data, names, ok := readLocally(item)
+	if !ok {
+		return false
+	}
+	if iconName(*item) == "image-x-generic" && displayImages(names, data) {
+		return true
+	}
+	if IsText(data[0]) && IsText(data[1]) {
+		cmp, ok := Compare(data[0], data[1])
+		if ok {
+			displayComparison(cmp, names[0], names[1])
+		}
+		return ok
+	}
+	displayHexDump(HexDump(data[0], data[1]), names[0], names[1])
+	return true
+}
+
+// compareLocally compares item's versions, returning also their file names. It returns false
+// if that's impossible, e.g. because one of the replicas is remote or the file is not text.
+func compareLocally(item *Item) (*Comparison, [2]string, bool) {
+	data, names, ok := readLocally(item)
+	if !ok || !IsText(data[0]) || !IsText(data[1]) {
+		return nil, names, false
+	}
+	cmp, ok := Compare(data[0], data[1])
+	return cmp, names, ok
+}
+
+// readLocally reads both versions of item, returning also their file names. It returns false
+// if that's impossible, e.g. because one of the replicas is remote or the item is not a file.
+func readLocally(item *Item) ([2][]byte, [2]string, bool) {
+	var data [2][]byte
+	var names [2]string
+	if item.Left.Type != File || item.Right.Type != File {
+		return data, names, false
+	}
+	for i, root := range []Root{core.LeftRoot, core.RightRoot} {
+		var ok bool
+		if names[i], ok = LocalPath(root, item.Path); !ok {
+			return data, names, false
+		}
+		var err error
+		if data[i], err = os.ReadFile(names[i]); err != nil {
+			log.Printf("cannot read %s for comparison: %s", names[i], err)
+			return data, names, false
+		}
+	}
+	return data, names, true
+}
+
+// displayDiff shows diff in the diff window, replacing whatever was there before.
+func displayDiff(diff []byte) {
+	beginDiff()
+	appendDiff(diff)
+	endDiff()
+}
+
+// A diff from Unison arrives in chunks (see Update.DiffChunk), which are shown as they come,
+// but may break lines anywhere.
+var (
+	diffReceiving  bool           // whether a diff is being received
+	diffClassifier DiffClassifier // of the diff being received
+	diffPartial    string         // last line received so far, without its terminator
+	diffLineCount  int            // lines received so far
+)
+
+// beginDiff clears the diff window and shows it, ready to receive a diff.
+func beginDiff() {
+	diffReceiving = true
+	diffClassifier = DiffClassifier{}
+	diffPartial = ""
+	diffLineCount = 0
+	diffText = nil
+	diffHunks = diffHunks[:0]
+	diffBuffer.SetText("")
+	showDiffPanes("unified", diffPane{diffView, diffBuffer})
+}
+
+// appendDiff shows another chunk of the diff being received.
+func appendDiff(chunk []byte) {
+	diffText = append(diffText, chunk...)
+	lines := strings.SplitAfter(diffPartial+string(chunk), "\n")
+	diffPartial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		appendDiffLine(line)
+	}
+}
+
+// endDiff shows the rest of the diff received, and resets the window for viewing it.
+func endDiff() {
+	if diffPartial != "" {
+		appendDiffLine(diffPartial)
+		diffPartial = ""
+	}
+	diffReceiving = false
+	showDiffPanes("unified", diffPane{diffView, diffBuffer})
+}
+
+func appendDiffLine(line string) {
+	// GtkTextBuffer only accepts UTF-8, while files can be in any encoding.
+	line = strings.ToValidUTF8(line, "\uFFFD")
+	kind := diffClassifier.Classify(strings.TrimRight(line, "\r\n"))
+	if kind == DiffHunk {
+		diffHunks = append(diffHunks, diffLineCount)
+	}
+	diffLineCount++
+	iter := diffBuffer.GetEndIter()
+	if tag, ok := diffLineTags[kind]; ok {
+		diffBuffer.InsertWithTagByName(iter, line, tag)
+	} else {
+		diffBuffer.Insert(iter, line)
+	}
+}
+
+// displayComparison shows cmp in the diff window, replacing whatever was there before.
+func displayComparison(cmp *Comparison, leftName, rightName string) {
+	diffText = []byte(cmp.Unified(leftName, rightName))
+	diffHunks = cmp.Changes()
+	diffLeftBuffer.SetText("")
+	diffRightBuffer.SetText("")
+	left, right := diffLeftBuffer.GetEndIter(), diffRightBuffer.GetEndIter()
+	for _, row := range cmp.Rows {
+		// Line terminators are inserted along with the tags, so that even empty lines get
+		// the paragraph background.
+		switch row.Kind {
+		case RowSame:
+			diffLeftBuffer.Insert(left, row.Left+"\n")
+			diffRightBuffer.Insert(right, row.Right+"\n")
+		case RowChanged:
+			insertWithSpans(diffLeftBuffer, left, row.Left, row.LeftSpans, "removed", "removed-span")
+			insertWithSpans(diffRightBuffer, right, row.Right, row.RightSpans, "added", "added-span")
+		case RowRemoved:
+			diffLeftBuffer.InsertWithTagByName(left, row.Left+"\n", "removed")
+			diffRightBuffer.InsertWithTagByName(right, "\n", "filler")
+		case RowAdded:
+			diffLeftBuffer.InsertWithTagByName(left, "\n", "filler")
+			diffRightBuffer.InsertWithTagByName(right, row.Right+"\n", "added")
+		}
+	}
+	showDiffPanes("side-by-side",
+		diffPane{diffLeftView, diffLeftBuffer}, diffPane{diffRightView, diffRightBuffer})
+}
+
+// displayHexDump shows hc in the diff window, like displayComparison, additionally emphasizing
+// the first byte that differs and moving the cursor to it.
+func displayHexDump(hc *HexComparison, leftName, rightName string) {
+	displayComparison(&hc.Comparison, leftName, rightName)
+	if hc.Offset == -1 {
+		return
+	}
+	diffHeaderbar.SetSubtitle(fmt.Sprintf("%s (first difference at byte %d)", diffPath, hc.Offset))
+	for _, pane := range diffPanes {
+		// On a side that ends before the first difference, its line is a filler.
+		if pane.buffer.GetIterAtLine(hc.Row).GetCharsInLine() < hc.Span.End {
+			continue
+		}
+		pane.buffer.ApplyTagByName("first-difference",
+			pane.buffer.GetIterAtLineOffset(hc.Row, hc.Span.Start),
+			pane.buffer.GetIterAtLineOffset(hc.Row, hc.Span.End))
+	}
+	gotoDiffLine(hc.Row)
+}
+
+// maxPreviewSize is the largest width or height of an image preview, in pixels.
+const maxPreviewSize = 512
+
+// displayImages shows previews of the images in the named files, whose contents are data,
+// side by side in the diff window. It returns false if either can't be loaded.
+func displayImages(names [2]string, data [2][]byte) bool {
+	var pixbufs [2]*gdk.Pixbuf
+	var captions [2]string
+	for i, name := range names {
+		_, width, height, err := gdk.PixbufGetFileInfo(name)
+		if err == nil {
+			w, h := width, height
+			if w > maxPreviewSize || h > maxPreviewSize {
+				w, h = maxPreviewSize, maxPreviewSize // the aspect ratio is preserved
+			}
+			pixbufs[i], err = gdk.PixbufNewFromFileAtSize(name, w, h)
+		}
+		if err != nil {
+			log.Printf("cannot load %s for preview: %s", name, err)
+			return false
+		}
+		captions[i] = fmt.Sprintf("%d × %d pixels, %s", width, height, glib.FormatSize(uint64(len(data[i]))))
+	}
+	diffText = nil
+	diffHunks = diffHunks[:0]
+	diffLeftImage.SetFromPixbuf(pixbufs[0])
+	diffLeftCaption.SetText(captions[0])
+	diffRightImage.SetFromPixbuf(pixbufs[1])
+	diffRightCaption.SetText(captions[1])
+	showDiffPanes("images")
+	return true
+}
+
+// insertWithSpans inserts line and a line terminator at iter with lineTag,
+// additionally applying spanTag to the given spans of line.
+func insertWithSpans(buffer *gtk.TextBuffer, iter *gtk.TextIter, line string, spans []Span,
+	lineTag, spanTag string) {
+	pos := 0
+	for _, span := range spans {
+		buffer.InsertWithTagByName(iter, line[pos:span.Start], lineTag)
+		start := iter.GetOffset()
+		buffer.InsertWithTagByName(iter, line[span.Start:span.End], lineTag)
+		buffer.ApplyTagByName(spanTag, buffer.GetIterAtOffset(start), iter)
+		pos = span.End
+	}
+	buffer.InsertWithTagByName(iter, line[pos:]+"\n", lineTag)
+}
+
+func showDiffPanes(name string, panes ...diffPane) {
+	diffPanes = panes
+	diffFocus = 0
+	diffStack.SetVisibleChildName(name)
+	diffHeaderbar.SetSubtitle(diffPath)
+	// Images can't be navigated, searched, or saved.
+	for _, w := range []interface{ SetSensitive(bool) }{
+		prevHunkButton, nextHunkButton, saveDiffButton, diffSearchEntry,
+	} {
+		w.SetSensitive(len(panes) > 0)
+	}
+	if len(panes) == 0 {
+		diffSearchEntry.SetText("") // a search left over from a previous diff has nothing to find
+	}
+	for _, pane := range diffPanes {
+		pane.buffer.PlaceCursor(pane.buffer.GetStartIter())
+	}
+	if text, _ := diffSearchEntry.GetText(); text != "" && len(panes) > 0 {
+		findInDiff(text, 0, diffPanes[0].buffer.GetStartIter(), true)
+	}
+	diffWindow.Present()
+}
+
+func onDiffWindowDeleteEvent() bool {
+	diffWindow.Hide()
+	return blockDefault // keep the window around for the next diff
+}
+
+func onDiffWindowKeyPressEvent(_ *gtk.Window, ev *gdk.Event) bool {
+	evk := gdk.EventKeyNewFromEvent(ev)
+	mods := gdk.ModifierType(evk.State()) & (gdk.CONTROL_MASK | gdk.MOD1_MASK | gdk.SHIFT_MASK)
+	switch {
+	case len(diffPanes) == 0: // showing images
+		if mods == 0 && evk.KeyVal() == gdk.KEY_Escape {
+			diffWindow.Hide()
+			return blockDefault
+		}
+		return handleDefault
+	case mods == gdk.MOD1_MASK && evk.KeyVal() == gdk.KEY_Up:
+		gotoHunk(false)
+	case mods == gdk.MOD1_MASK && evk.KeyVal() == gdk.KEY_Down:
+		gotoHunk(true)
+	case mods == gdk.CONTROL_MASK && evk.KeyVal() == gdk.KEY_f:
+		diffSearchEntry.GrabFocus()
+	case mods == gdk.CONTROL_MASK && evk.KeyVal() == gdk.KEY_s:
+		saveDiff()
+	case mods == 0 && evk.KeyVal() == gdk.KEY_Escape:
+		if text, _ := diffSearchEntry.GetText(); text != "" {
+			diffSearchEntry.SetText("")
+		} else {
+			diffWindow.Hide()
+		}
+	default:
+		return handleDefault
+	}
+	return blockDefault
+}
+
+func onDiffPaneFocusInEvent(view *gtk.TextView) bool {
+	for i, pane := range diffPanes {
+		if pane.view.Native() == view.Native() {
+			diffFocus = i
+		}
+	}
+	return handleDefault
+}
+
+func onPrevHunkButtonClicked() {
+	gotoHunk(false)
+}
+
+func onNextHunkButtonClicked() {
+	gotoHunk(true)
+}
+
+// gotoHunk moves the cursor to the start of the next (or previous) hunk after (or before)
+// the line where the cursor is. Side-by-side panes have the same lines, so they move together.
+func gotoHunk(forward bool) {
+	pane := diffPanes[diffFocus]
+	cur := pane.buffer.GetIterAtMark(pane.buffer.GetInsert()).GetLine()
+	target := invalid
+	if forward {
+		for _, line := range diffHunks {
+			if line > cur {
+				target = line
+				break
+			}
+		}
+	} else {
+		for i := len(diffHunks) - 1; i >= 0; i-- {
+			if diffHunks[i] < cur {
+				target = diffHunks[i]
+				break
+			}
+		}
+	}
+	if target != invalid {
+		gotoDiffLine(target)
+	}
+}
+
+// gotoDiffLine moves the cursor to the start of line in all diffPanes,
+// scrolling to it in the one where the user was last searching or navigating.
+func gotoDiffLine(line int) {
+	for _, pane := range diffPanes {
+		pane.buffer.PlaceCursor(pane.buffer.GetIterAtLine(line))
+	}
+	pane := diffPanes[diffFocus]
+	pane.view.ScrollToMark(pane.buffer.GetInsert(), 0, true, 0, 0.1)
+	pane.view.GrabFocus()
+}
+
+func onDiffSearchEntrySearchChanged() {
+	if len(diffPanes) == 0 { // showing images
+		return
+	}
+	text, _ := diffSearchEntry.GetText()
+	findInDiff(text, 0, diffPanes[0].buffer.GetStartIter(), true)
+}
+
+func onDiffSearchEntryNextMatch() {
+	if len(diffPanes) == 0 { // showing images
+		return
+	}
+	text, _ := diffSearchEntry.GetText()
+	buffer := diffPanes[diffFocus].buffer
+	_, end, ok := buffer.GetSelectionBounds()
+	if !ok {
+		end = buffer.GetIterAtMark(buffer.GetInsert())
+	}
+	findInDiff(text, diffFocus, end, true)
+}
+
+func onDiffSearchEntryPreviousMatch() {
+	if len(diffPanes) == 0 { // showing images
+		return
+	}
+	text, _ := diffSearchEntry.GetText()
+	buffer := diffPanes[diffFocus].buffer
+	start, _, ok := buffer.GetSelectionBounds()
+	if !ok {
+		start = buffer.GetIterAtMark(buffer.GetInsert())
+	}
+	findInDiff(text, diffFocus, start, false)
+}
+
+// findInDiff highlights all occurrences of text in diffPanes, then selects and scrolls to
+// the first one after (or before) from in diffPanes[focus], continuing into the next
+// (or previous) panes and wrapping around.
+func findInDiff(text string, focus int, from *gtk.TextIter, forward bool) {
+	const flags = gtk.TEXT_SEARCH_CASE_INSENSITIVE | gtk.TEXT_SEARCH_TEXT_ONLY
+	style, err := diffSearchEntry.GetStyleContext()
+	mustf(err, "get style context")
+	style.RemoveClass("error")
+	for _, pane := range diffPanes {
+		pane.buffer.RemoveTagByName("match", pane.buffer.GetStartIter(), pane.buffer.GetEndIter())
+		for iter := pane.buffer.GetStartIter(); text != ""; {
+			start, end, ok := iter.ForwardSearch(text, flags, nil)
+			if !ok {
+				break
+			}
+			pane.buffer.ApplyTagByName("match", start, end)
+			iter = end
+		}
+	}
+	if text == "" {
+		return
+	}
+
+	n := len(diffPanes)
+	for step := 0; step <= n; step++ {
+		var i int
+		var start, end *gtk.TextIter
+		var ok bool
+		if forward {
+			i = (focus + step) % n
+			if step > 0 {
+				from = diffPanes[i].buffer.GetStartIter()
+			}
+			start, end, ok = from.ForwardSearch(text, flags, nil)
+		} else {
+			i = (focus - step + n) % n
+			if step > 0 {
+				from = diffPanes[i].buffer.GetEndIter()
+			}
+			start, end, ok = from.BackwardSearch(text, flags, nil)
+		}
+		if ok {
+			pane := diffPanes[i]
+			diffFocus = i
+			pane.buffer.SelectRange(start, end)
+			pane.view.ScrollToMark(pane.buffer.GetInsert(), 0, true, 0, 0.3)
+			return
+		}
+	}
+	style.AddClass("error")
+}
+
+func onSaveDiffButtonClicked() {
+	saveDiff()
+}
+
+func saveDiff() {
+	chooser, err := gtk.FileChooserNativeDialogNew("Save differences", diffWindow,
+		gtk.FILE_CHOOSER_ACTION_SAVE, "_Save", "_Cancel")
+	if !shouldf(err, "create file chooser") {
+		return
+	}
+	defer chooser.Destroy()
+	chooser.SetDoOverwriteConfirmation(true)
+	chooser.SetCurrentName("changes.diff")
+	if chooser.Run() != int(gtk.RESPONSE_ACCEPT) {
+		return
+	}
+	name := chooser.GetFilename()
+	checkf(os.WriteFile(name, diffText, 0o644), "save differences to %v", name)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// ExportFormat is a file format for ExportPlan.
+type ExportFormat byte
+
+const (
+	ExportJSON ExportFormat = iota
+	ExportCSV
+	ExportMarkdown
+)
+
+// ExportVersion is the version of the JSON schema written by ExportPlan. It will be incremented
+// only when the schema changes incompatibly; new fields may be added without incrementing it.
+const ExportVersion = 1
+
+// An ExportedPlan is the top-level object of the JSON written by ExportPlan.
+type ExportedPlan struct {
+	Version   int            `json:"version"` // ExportVersion
+	LeftRoot  ExportedRoot   `json:"left_root"`
+	RightRoot ExportedRoot   `json:"right_root"`
+	Items     []ExportedItem `json:"items"` // in the same order as in the plan
+}
+
+type ExportedRoot struct {
+	Host string `json:"host"`
+	Path string `json:"path"`
+}
+
+type ExportedItem struct {
+	Path           string          `json:"path"`
+	Left           ExportedContent `json:"left"`
+	Right          ExportedContent `json:"right"`
+	Recommendation string          `json:"recommendation"` // one of actionNames
+	Override       string          `json:"override"`       // one of actionNames, or empty if not set by the user or a rule
+	Rule           string          `json:"rule"`           // the Text of the Rule that has set Override, or empty if none
+	Action         string          `json:"action"`         // what Sync will do: Override if set, otherwise Recommendation
+}
+
+type ExportedContent struct {
+	Type   string `json:"type"`   // one of typeNames, or empty if unknown (for items with problems)
+	Status string `json:"status"` // one of statusNames, or empty if unknown (also for absent items)
+	Props  string `json:"props"`  // as Unison describes them, e.g. "modified on 2021-02-06 at 18:35:06  size 1146  rw-r--r--"
+}
+
+// Names of types, statuses and actions as exported. They must never change,
+// so that scripts relying on them don't break.
+var (
+	typeNames = map[Type]string{
+		Absent:    "absent",
+		File:      "file",
+		Directory: "directory",
+		Symlink:   "symlink",
+	}
+	statusNames = map[Status]string{
+		Unchanged:    "unchanged",
+		Created:      "created",
+		Modified:     "modified",
+		PropsChanged: "props-changed",
+		Deleted:      "deleted",
+	}
+	actionNames = map[Action]string{
+		LeftToRight:        "left-to-right",
+		LeftToRightPartial: "left-to-right-partial",
+		RightToLeft:        "right-to-left",
+		RightToLeftPartial: "right-to-left-partial",
+		Merge:              "merge",
+		Skip:               "skip",
+		Problem:            "problem",
+	}
+)
+
+// ExportFormatFor chooses the format for a file by its name's extension: .csv, .md or .markdown.
+// Any other name gets JSON.
+func ExportFormatFor(name string) ExportFormat {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return ExportCSV
+	case ".md", ".markdown":
+		return ExportMarkdown
+	default:
+		return ExportJSON
+	}
+}
+
+// ExportPlan writes the plan of c (its roots and Items) to w in the given format.
+func ExportPlan(w io.Writer, format ExportFormat, c *Core) error {
+	plan := ExportedPlan{
+		Version:   ExportVersion,
+		LeftRoot:  ExportedRoot{c.LeftRoot.Host, c.LeftRoot.Path},
+		RightRoot: ExportedRoot{c.RightRoot.Host, c.RightRoot.Path},
+		Items:     make([]ExportedItem, 0, len(c.Items)),
+	}
+	for _, item := range c.Items {
+		plan.Items = append(plan.Items, exportItem(item))
+	}
+	switch format {
+	case ExportCSV:
+		return exportCSV(w, plan)
+	case ExportMarkdown:
+		return exportMarkdown(w, plan)
+	default:
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plan)
+	}
+}
+
+func exportItem(item Item) ExportedItem {
+	exported := ExportedItem{
+		Path:           item.Path,
+		Left:           exportContent(item.Left),
+		Right:          exportContent(item.Right),
+		Recommendation: actionNames[item.Recommendation],
+		Override:       actionNames[item.Override],
+		Action:         actionNames[item.Action()],
+	}
+	if item.IsAutomatic() {
+		exported.Rule = item.Rule.Text
+	}
+	return exported
+}
+
+func exportContent(c Content) ExportedContent {
+	return ExportedContent{
+		Type:   typeNames[c.Type],
+		Status: statusNames[c.Status],
+		Props:  c.Props,
+	}
+}
+
+func exportCSV(w io.Writer, plan ExportedPlan) error {
+	cw := csv.NewWriter(w)
+	records := [][]string{{
+		"path",
+		"left_type", "left_status", "left_props",
+		"right_type", "right_status", "right_props",
+		"recommendation", "override", "rule", "action",
+	}}
+	for _, item := range plan.Items {
+		records = append(records, []string{
+			item.Path,
+			item.Left.Type, item.Left.Status, item.Left.Props,
+			item.Right.Type, item.Right.Status, item.Right.Props,
+			item.Recommendation, item.Override, item.Rule, item.Action,
+		})
+	}
+	return cw.WriteAll(records)
+}
+
+func exportMarkdown(w io.Writer, plan ExportedPlan) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Left: %s\n\nRight: %s\n\n",
+		escapeMarkdown(describeRoot(plan.LeftRoot)), escapeMarkdown(describeRoot(plan.RightRoot)))
+	b.WriteString("| Path | Left | Right | Recommendation | Override | Rule | Action |\n")
+	b.WriteString("| --- | --- | --- | --- | --- | --- | --- |\n")
+	for _, item := range plan.Items {
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s | %s |\n",
+			escapeMarkdown(item.Path),
+			escapeMarkdown(describeExportedContent(item.Left)),
+			escapeMarkdown(describeExportedContent(item.Right)),
+			item.Recommendation, item.Override, escapeMarkdown(item.Rule), item.Action)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func describeRoot(r ExportedRoot) string {
+	if r.Host == "" {
+		return r.Path
+	}
+	return r.Host + ":" + r.Path
+}
+
+// describeExportedContent returns something like "modified file (size 1146  rw-r--r--)".
+func describeExportedContent(c ExportedContent) string {
+	var s string
+	switch {
+	case c.Status == "":
+		s = c.Type
+	case c.Type == "" || c.Type == typeNames[Absent]:
+		s = c.Status
+	default:
+		s = c.Status + " " + c.Type
+	}
+	if c.Props != "" {
+		s += " (" + c.Props + ")"
+	}
+	return s
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+	`<`, `\<`, `>`, `\>`, `#`, `\#`, `|`, `\|`,
+)
+
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportPlan(t *testing.T) {
+	plan := &Core{
+		LeftRoot:  Root{"laptop", "/home/user/Documents"},
+		RightRoot: Root{"server", "/srv/docs"},
+		Items: []Item{
+			item("notes/todo.txt", Modified, "size 20  rw-r--r--", LeftToRight,
+				Unchanged, "size 10  rw-r--r--"),
+			item("a|b_c.md", Modified, "size 2  rw-r--r--", Skip, Modified, "size 3  rw-r--r--", RightToLeft),
+			item("old", Directory, Deleted, "", LeftToRight, Directory, Unchanged, "", Skip),
+			{Path: "broken", Recommendation: Problem, Error: "No such file"},
+		},
+	}
+	plan.Items[2].Rule = &Rule{Text: "skip old", Action: Skip}
+	cases := []struct {
+		name     string
+		format   ExportFormat
+		expected string
+	}{
+		{
+			name:   lineno(),
+			format: ExportJSON,
+			expected: `{
+  "version": 1,
+  "left_root": {
+    "host": "laptop",
+    "path": "/home/user/Documents"
+  },
+  "right_root": {
+    "host": "server",
+    "path": "/srv/docs"
+  },
+  "items": [
+    {
+      "path": "notes/todo.txt",
+      "left": {
+        "type": "file",
+        "status": "modified",
+        "props": "size 20  rw-r--r--"
+      },
+      "right": {
+        "type": "file",
+        "status": "unchanged",
+        "props": "size 10  rw-r--r--"
+      },
+      "recommendation": "left-to-right",
+      "override": "",
+      "rule": "",
+      "action": "left-to-right"
+    },
+    {
+      "path": "a|b_c.md",
+      "left": {
+        "type": "file",
+        "status": "modified",
+        "props": "size 2  rw-r--r--"
+      },
+      "right": {
+        "type": "file",
+        "status": "modified",
+        "props": "size 3  rw-r--r--"
+      },
+      "recommendation": "skip",
+      "override": "right-to-left",
+      "rule": "",
+      "action": "right-to-left"
+    },
+    {
+      "path": "old",
+      "left": {
+        "type": "directory",
+        "status": "deleted",
+        "props": ""
+      },
+      "right": {
+        "type": "directory",
+        "status": "unchanged",
+        "props": ""
+      },
+      "recommendation": "left-to-right",
+      "override": "skip",
+      "rule": "skip old",
+      "action": "skip"
+    },
+    {
+      "path": "broken",
+      "left": {
+        "type": "",
+        "status": "",
+        "props": ""
+      },
+      "right": {
+        "type": "",
+        "status": "",
+        "props": ""
+      },
+      "recommendation": "problem",
+      "override": "",
+      "rule": "",
+      "action": "problem"
+    }
+  ]
+}
+`,
+		},
+		{
+			name:   lineno(),
+			format: ExportCSV,
+			expected: `path,left_type,left_status,left_props,right_type,right_status,right_props,recommendation,override,rule,action
+notes/todo.txt,file,modified,size 20  rw-r--r--,file,unchanged,size 10  rw-r--r--,left-to-right,,,left-to-right
+a|b_c.md,file,modified,size 2  rw-r--r--,file,modified,size 3  rw-r--r--,skip,right-to-left,,right-to-left
+old,directory,deleted,,directory,unchanged,,left-to-right,skip,skip old,skip
+broken,,,,,,,problem,,,problem
+`,
+		},
+		{
+			name:   lineno(),
+			format: ExportMarkdown,
+			expected: `Left: laptop:/home/user/Documents
+
+Right: server:/srv/docs
+
+| Path | Left | Right | Recommendation | Override | Rule | Action |
+| --- | --- | --- | --- | --- | --- | --- |
+| notes/todo.txt | modified file (size 20  rw-r--r--) | unchanged file (size 10  rw-r--r--) | left-to-right |  |  | left-to-right |
+| a\|b\_c.md | modified file (size 2  rw-r--r--) | modified file (size 3  rw-r--r--) | skip | right-to-left |  | right-to-left |
+| old | deleted directory | unchanged directory | left-to-right | skip | skip old | skip |
+| broken |  |  | problem |  |  | problem |
+`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var b strings.Builder
+			require.NoError(t, ExportPlan(&b, c.format, plan))
+			assertEqual(t, b.String(), c.expected)
+		})
+	}
+}
+
+func TestExportFormatFor(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected ExportFormat
+	}{
+		{"plan.json", ExportJSON},
+		{"plan.CSV", ExportCSV},
+		{"plan.md", ExportMarkdown},
+		{"plan.markdown", ExportMarkdown},
+		{"plan", ExportJSON},
+		{"plan.txt", ExportJSON},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assertEqual(t, ExportFormatFor(c.name), c.expected)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// A Filter narrows down which Items are displayed. The zero Filter matches all Items.
+type Filter struct {
+	// Matched against Path: as a glob if it contains wildcards (also against the last segment
+	// of Path alone, so that "*.txt" works anywhere), otherwise as a case-insensitive substring.
+	Pattern string
+	Only    Only   // a category of Items
+	Status  Status // of either side; 0 means any
+	Type    Type   // of either side; 0 means any
+}
+
+// Only is a category of Items that a Filter may be limited to.
+type Only byte
+
+const (
+	OnlyAny        Only = iota
+	OnlyConflicts       // as counted in the Summary (see isConflict)
+	OnlyDeletions       // the action will delete something
+	OnlyOverridden      // the action has been set by the user (or by a rule)
+)
+
+// Match reports whether item passes f.
+func (f Filter) Match(item Item) bool {
+	if f.Pattern != "" && !matchPattern(f.Pattern, item.Path) {
+		return false
+	}
+	switch f.Only {
+	case OnlyConflicts:
+		if !isConflict(item) {
+			return false
+		}
+	case OnlyDeletions:
+		if left, right := deletions(item); !left && !right {
+			return false
+		}
+	case OnlyOverridden:
+		if !item.IsOverridden() {
+			return false
+		}
+	}
+	if f.Status != 0 && item.Left.Status != f.Status && item.Right.Status != f.Status {
+		return false
+	}
+	if f.Type != 0 && item.Left.Type != f.Type && item.Right.Type != f.Type {
+		return false
+	}
+	return true
+}
+
+func matchPattern(pattern, p string) bool {
+	if strings.ContainsAny(pattern, "*?[") {
+		if ok, err := path.Match(pattern, p); err == nil {
+			if !ok && p != "" {
+				ok, _ = path.Match(pattern, path.Base(p))
+			}
+			return ok
+		}
+		// Not a valid glob after all, so treat it as a substring.
+	}
+	return strings.Contains(strings.ToLower(p), strings.ToLower(pattern))
+}
+
+// deletions reports whether item's action will delete it in the left and right replicas.
+func deletions(item Item) (left, right bool) {
+	//nolint:exhaustive
+	switch item.Action() {
+	case LeftToRight, LeftToRightPartial:
+		right = item.Left.Status == Deleted
+	case RightToLeft, RightToLeftPartial:
+		left = item.Right.Status == Deleted
+	}
+	return
+}
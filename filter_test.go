@@ -0,0 +1,147 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	cases := []struct {
+		name     string
+		filter   Filter
+		item     Item
+		expected bool
+	}{
+		{
+			name:     lineno(),
+			filter:   Filter{},
+			item:     item("foo/bar.txt"),
+			expected: true,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Pattern: "O/B"},
+			item:     item("foo/bar.txt"),
+			expected: true,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Pattern: "baz"},
+			item:     item("foo/bar.txt"),
+			expected: false,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Pattern: "*.txt"},
+			item:     item("foo/bar.txt"),
+			expected: true,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Pattern: "foo/*"},
+			item:     item("foo/bar.txt"),
+			expected: true,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Pattern: "foo/*"},
+			item:     item("foo/bar/baz.txt"),
+			expected: false,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Pattern: "*.TXT"},
+			item:     item("foo/bar.txt"),
+			expected: false,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Pattern: "[bar"}, // not a valid glob
+			item:     item("foo/[bar].txt"),
+			expected: true,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Only: OnlyConflicts},
+			item:     item("foo", Skip),
+			expected: true,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Only: OnlyConflicts},
+			item:     item("foo", LeftToRight, Skip),
+			expected: false,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Only: OnlyConflicts},
+			item:     item("foo", Skip, RightToLeft), // resolved by the user
+			expected: false,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Only: OnlyConflicts},
+			item:     item("foo", Skip, Skip), // the user has decided to skip it
+			expected: false,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Only: OnlyDeletions},
+			item:     item("foo", Deleted, Absent, LeftToRight),
+			expected: true,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Only: OnlyDeletions},
+			item:     item("foo", Deleted, Absent, LeftToRight, Modified, RightToLeft),
+			expected: false,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Only: OnlyDeletions},
+			item:     item("foo", RightToLeft, Deleted, Absent),
+			expected: true,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Only: OnlyOverridden},
+			item:     item("foo", LeftToRight, Skip),
+			expected: true,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Only: OnlyOverridden},
+			item:     item("foo"),
+			expected: false,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Status: Unchanged},
+			item:     item("foo"),
+			expected: true,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Status: Created},
+			item:     item("foo"),
+			expected: false,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Type: Directory},
+			item:     item("foo", Directory, PropsChanged, LeftToRight, Directory),
+			expected: true,
+		},
+		{
+			name:     lineno(),
+			filter:   Filter{Pattern: "foo", Type: Symlink},
+			item:     item("foo"),
+			expected: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assertEqual(t, c.filter.Match(c.item), c.expected)
+		})
+	}
+}
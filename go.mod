@@ -4,7 +4,6 @@ go 1.17
 
 require (
 	github.com/gotk3/gotk3 v0.5.3-0.20210514043925-3f44af595c5e
-	github.com/skratchdot/open-golang v0.0.0-20200116055534-eef842397966
 	github.com/stretchr/testify v1.7.0
 	pgregory.net/rapid v0.4.5
 )
@@ -0,0 +1,56 @@
+package main
+
+// An OverrideChange records that the Override of the Item with Path changed from Old to New,
+// and its Rule from OldRule to NewRule (so that undo restores an Override set by a rule).
+type OverrideChange struct {
+	Path             string
+	Old, New         Action
+	OldRule, NewRule *Rule
+}
+
+// History keeps groups of OverrideChanges (one group per user command) for undo and redo.
+// The zero History is empty.
+type History struct {
+	done   [][]OverrideChange
+	undone [][]OverrideChange
+}
+
+// Record adds changes as one group that can be undone, and forgets what can be redone.
+// An empty group is not recorded.
+func (h *History) Record(changes []OverrideChange) {
+	if len(changes) == 0 {
+		return
+	}
+	h.done = append(h.done, changes)
+	h.undone = nil
+}
+
+// Undo returns the changes that revert the last recorded (or redone) group, or nil if none.
+func (h *History) Undo() []OverrideChange {
+	if len(h.done) == 0 {
+		return nil
+	}
+	group := h.done[len(h.done)-1]
+	h.done = h.done[:len(h.done)-1]
+	h.undone = append(h.undone, group)
+	reverse := make([]OverrideChange, len(group))
+	for i, change := range group {
+		// In reverse order, in case the same Path occurs multiple times.
+		reverse[len(group)-1-i] = OverrideChange{change.Path, change.New, change.Old, change.NewRule, change.OldRule}
+	}
+	return reverse
+}
+
+// Redo returns the changes of the last undone group, or nil if none.
+func (h *History) Redo() []OverrideChange {
+	if len(h.undone) == 0 {
+		return nil
+	}
+	group := h.undone[len(h.undone)-1]
+	h.undone = h.undone[:len(h.undone)-1]
+	h.done = append(h.done, group)
+	return group
+}
+
+func (h *History) CanUndo() bool { return len(h.done) > 0 }
+func (h *History) CanRedo() bool { return len(h.undone) > 0 }
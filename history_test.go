@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pgregory.net/rapid"
+)
+
+func TestHistory(t *testing.T) {
+	var h History
+	assert.False(t, h.CanUndo())
+	assert.Nil(t, h.Undo())
+	assert.Nil(t, h.Redo())
+
+	h.Record([]OverrideChange{{"foo", NoAction, LeftToRight, nil, nil}, {"bar", Skip, LeftToRight, nil, nil}})
+	h.Record(nil)
+	h.Record([]OverrideChange{{"foo", LeftToRight, Merge, nil, nil}})
+	assert.True(t, h.CanUndo())
+	assertEqual(t, h.Undo(), []OverrideChange{{"foo", Merge, LeftToRight, nil, nil}})
+	assertEqual(t, h.Undo(), []OverrideChange{{"bar", LeftToRight, Skip, nil, nil}, {"foo", LeftToRight, NoAction, nil, nil}})
+	assert.False(t, h.CanUndo())
+	assert.True(t, h.CanRedo())
+	assertEqual(t, h.Redo(), []OverrideChange{{"foo", NoAction, LeftToRight, nil, nil}, {"bar", Skip, LeftToRight, nil, nil}})
+
+	// A new change makes the undone one impossible to redo.
+	h.Record([]OverrideChange{{"baz", NoAction, RightToLeft, nil, nil}})
+	assert.False(t, h.CanRedo())
+	assert.Nil(t, h.Redo())
+	assertEqual(t, h.Undo(), []OverrideChange{{"baz", RightToLeft, NoAction, nil, nil}})
+
+	// Undo restores the rule that had set the override.
+	rule := &Rule{Text: "skip qux", Action: Skip}
+	h.Record([]OverrideChange{{"qux", Skip, LeftToRight, rule, nil}})
+	assertEqual(t, h.Undo(), []OverrideChange{{"qux", LeftToRight, Skip, nil, rule}})
+	assertEqual(t, h.Redo(), []OverrideChange{{"qux", Skip, LeftToRight, rule, nil}})
+}
+
+// TestHistoryRandom checks the following property:
+// Undoing returns overrides to what they were before the corresponding command,
+// and redoing returns them to what they were after it.
+func TestHistoryRandom(t *testing.T) {
+	paths := []string{"a", "b", "c", "d"}
+	actions := []Action{NoAction, LeftToRight, RightToLeft, Merge, Skip}
+	rapid.Check(t, func(t *rapid.T) {
+		var h History
+		state := map[string]Action{}
+		for _, p := range paths {
+			state[p] = NoAction
+		}
+		snapshot := func() map[string]Action {
+			m := make(map[string]Action, len(state))
+			for p, act := range state {
+				m[p] = act
+			}
+			return m
+		}
+		apply := func(changes []OverrideChange) {
+			for _, change := range changes {
+				assert.Equal(t, state[change.Path], change.Old, change.Path)
+				state[change.Path] = change.New
+			}
+		}
+		var before, after []map[string]Action // states that can be returned to by undo and redo
+
+		for n := rapid.IntRange(0, 30).Draw(t, "n").(int); n > 0; n-- {
+			switch rapid.SampledFrom([]string{"set", "undo", "redo"}).Draw(t, "command").(string) {
+			case "set":
+				act := rapid.SampledFrom(actions).Draw(t, "action").(Action)
+				var changes []OverrideChange
+				for _, p := range paths {
+					if rapid.Bool().Draw(t, "selected").(bool) && state[p] != act {
+						changes = append(changes, OverrideChange{p, state[p], act, nil, nil})
+					}
+				}
+				if len(changes) > 0 {
+					before = append(before, snapshot())
+					after = nil
+				}
+				apply(changes)
+				h.Record(changes)
+
+			case "undo":
+				assert.Equal(t, len(before) > 0, h.CanUndo())
+				cur := snapshot()
+				apply(h.Undo())
+				if len(before) > 0 {
+					assert.Equal(t, before[len(before)-1], state)
+					before = before[:len(before)-1]
+					after = append(after, cur)
+				}
+
+			case "redo":
+				assert.Equal(t, len(after) > 0, h.CanRedo())
+				cur := snapshot()
+				apply(h.Redo())
+				if len(after) > 0 {
+					assert.Equal(t, after[len(after)-1], state)
+					after = after[:len(after)-1]
+					before = append(before, cur)
+				}
+			}
+		}
+	})
+}
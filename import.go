@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A Decision is what the user (or a script) has decided to do with the Item with Path,
+// as read from a file by ReadDecisions.
+type Decision struct {
+	Path        string
+	Action      Action
+	Left, Right ExportedContent // as recorded when the decision was made; zero if not recorded
+}
+
+// decisionActions are the actions that can be decided, by their names in actionNames.
+var decisionActions = map[string]Action{}
+
+func init() {
+	for _, act := range []Action{LeftToRight, RightToLeft, Merge, Skip} {
+		decisionActions[actionNames[act]] = act
+	}
+}
+
+// ReadDecisions reads decisions in one of two formats. If the data begins with {, it is JSON
+// as written by ExportPlan, and every item with an override is a decision for its path,
+// except when the override was set by a rule, which is not the user's decision.
+// Otherwise, it is text with one decision per line: an action name, a space, and a path.
+// Blank lines and lines beginning with # are ignored.
+func ReadDecisions(r io.Reader) ([]Decision, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return readDecisionsJSON(data)
+	}
+	return readDecisionsText(data)
+}
+
+func readDecisionsJSON(data []byte) ([]Decision, error) {
+	var plan ExportedPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, err
+	}
+	if plan.Version > ExportVersion {
+		return nil, fmt.Errorf("Unsupported version %d: this Gunison understands up to %d.",
+			plan.Version, ExportVersion)
+	}
+	var decisions []Decision
+	for _, item := range plan.Items {
+		if item.Override == "" || item.Rule != "" {
+			continue
+		}
+		act, ok := decisionActions[item.Override]
+		if !ok {
+			return nil, fmt.Errorf("Item %s: cannot set action %q.", item.Path, item.Override)
+		}
+		decisions = append(decisions, Decision{item.Path, act, item.Left, item.Right})
+	}
+	return decisions, nil
+}
+
+func readDecisionsText(data []byte) ([]Decision, error) {
+	var decisions []Decision
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("Line %d: expected an action and a path.", lineno)
+		}
+		act, ok := decisionActions[fields[0]]
+		if !ok {
+			return nil, fmt.Errorf("Line %d: unknown action %q.", lineno, fields[0])
+		}
+		decisions = append(decisions, Decision{Path: fields[1], Action: act})
+	}
+	return decisions, scanner.Err()
+}
+
+// ResolveDecisions works out how decisions apply to items, without changing them. It returns
+// the changes to be made, and messages about the decisions that cannot be applied:
+// those whose paths are not in items, those whose recorded contents no longer match
+// (similar to Core.applyOverrides), and those that the items don't allow (see Item.CanSet).
+func ResolveDecisions(items []Item, decisions []Decision) ([]OverrideChange, []Message) {
+	index := make(map[string]int, len(items))
+	for i, item := range items {
+		index[item.Path] = i
+	}
+	var changes []OverrideChange
+	changeIndex := map[string]int{} // so that a later decision for the same path replaces an earlier one
+	var changed, problems, unmergeable, missing []string
+	for _, d := range decisions {
+		i, ok := index[d.Path]
+		if !ok {
+			missing = append(missing, d.Path)
+			continue
+		}
+		item := items[i]
+		switch {
+		case !matchesRecorded(item.Left, d.Left) || !matchesRecorded(item.Right, d.Right):
+			changed = append(changed, d.Path)
+			continue
+		case item.Recommendation == Problem:
+			problems = append(problems, d.Path)
+			continue
+		case !item.CanSet(d.Action): // which now can only be due to merging
+			unmergeable = append(unmergeable, d.Path)
+			continue
+		}
+		if j, ok := changeIndex[d.Path]; ok {
+			changes[j].New = d.Action
+		} else {
+			changeIndex[d.Path] = len(changes)
+			changes = append(changes, OverrideChange{d.Path, item.Override, d.Action, item.Rule, nil})
+		}
+	}
+	// Some decisions don't change anything, perhaps after a later one for the same path
+	// (but deciding what a rule has set makes it the user's decision).
+	var kept []OverrideChange
+	for _, change := range changes {
+		if change.New != change.Old || change.OldRule != nil {
+			kept = append(kept, change)
+		}
+	}
+
+	var msgs []Message
+	if changed != nil {
+		msgs = append(msgs, Message{
+			"These items have changed since the decisions were made, so they were not applied:\n" +
+				strings.Join(changed, "\n"),
+			Warning,
+		})
+	}
+	if problems != nil {
+		msgs = append(msgs, Message{
+			"Unison cannot synchronize these items, so the decisions about them were ignored:\n" +
+				strings.Join(problems, "\n"),
+			Warning,
+		})
+	}
+	if unmergeable != nil {
+		msgs = append(msgs, Message{
+			"These items are not files on both sides, so they cannot be merged:\n" +
+				strings.Join(unmergeable, "\n"),
+			Warning,
+		})
+	}
+	if missing != nil {
+		msgs = append(msgs, Message{
+			"These items are not in the plan, so the decisions about them were ignored:\n" +
+				strings.Join(missing, "\n"),
+			Info,
+		})
+	}
+	return kept, msgs
+}
+
+// matchesRecorded reports whether c is the same as recorded, if anything was recorded.
+func matchesRecorded(c Content, recorded ExportedContent) bool {
+	return recorded == (ExportedContent{}) || exportContent(c) == recorded
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadDecisions(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected []Decision
+		err      string
+	}{
+		{
+			name: lineno(),
+			input: "# reviewed by Alice\n" +
+				"left-to-right notes/todo.txt\n" +
+				"\n" +
+				"skip my documents/a b.txt\r\n" +
+				"merge \n",
+			expected: []Decision{
+				{Path: "notes/todo.txt", Action: LeftToRight},
+				{Path: "my documents/a b.txt", Action: Skip},
+				{Path: "", Action: Merge},
+			},
+		},
+		{
+			name:  lineno(),
+			input: "right-to-left foo\nleft-to-right-partial bar\n",
+			err:   `Line 2: unknown action "left-to-right-partial".`,
+		},
+		{
+			name:  lineno(),
+			input: "skip\n",
+			err:   "Line 1: expected an action and a path.",
+		},
+		{
+			name:     lineno(),
+			input:    "",
+			expected: nil,
+		},
+		{
+			name: lineno(),
+			input: `  {"version": 1, "items": [
+				{"path": "foo", "override": "skip"},
+				{"path": "bar", "override": ""},
+				{"path": "qux", "override": "skip", "rule": "skip qux"},
+				{"path": "baz", "override": "right-to-left",
+				 "left": {"type": "file", "status": "modified", "props": "size 2"},
+				 "right": {"type": "file", "status": "unchanged", "props": "size 3"}}
+			]}`,
+			expected: []Decision{
+				{Path: "foo", Action: Skip},
+				{
+					Path:   "baz",
+					Action: RightToLeft,
+					Left:   ExportedContent{"file", "modified", "size 2"},
+					Right:  ExportedContent{"file", "unchanged", "size 3"},
+				},
+			},
+		},
+		{
+			name:  lineno(),
+			input: `{"version": 2, "items": []}`,
+			err:   "Unsupported version 2: this Gunison understands up to 1.",
+		},
+		{
+			name:  lineno(),
+			input: `{"version": 1, "items": [{"path": "foo", "override": "problem"}]}`,
+			err:   `Item foo: cannot set action "problem".`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			decisions, err := ReadDecisions(strings.NewReader(c.input))
+			if c.err != "" {
+				assert.EqualError(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			assertEqual(t, decisions, c.expected)
+		})
+	}
+}
+
+func TestResolveDecisions(t *testing.T) {
+	rule := &Rule{Text: "skip quux", Action: Skip}
+	items := []Item{
+		item("foo", Modified, "size 2", LeftToRight, Unchanged, "size 3"),
+		item("bar", Modified, "size 2", Skip, Modified, "size 3", RightToLeft),
+		item("baz", Directory, Modified, "", LeftToRight, Directory, Unchanged, ""),
+		{Path: "qux", Recommendation: Problem},
+		item("quux", Skip, Skip),
+	}
+	items[4].Rule = rule
+	cases := []struct {
+		name      string
+		decisions []Decision
+		changes   []OverrideChange
+		messages  []Message
+	}{
+		{
+			name: lineno(),
+			decisions: []Decision{
+				{Path: "foo", Action: Skip},
+				{Path: "bar", Action: RightToLeft}, // already set
+				{Path: "baz", Action: RightToLeft},
+				{Path: "qux", Action: LeftToRight},
+			},
+			changes: []OverrideChange{
+				{"foo", NoAction, Skip, nil, nil},
+				{"baz", NoAction, RightToLeft, nil, nil},
+			},
+			messages: []Message{
+				{"Unison cannot synchronize these items, so the decisions about them were ignored:\nqux", Warning},
+			},
+		},
+		{
+			name: lineno(),
+			decisions: []Decision{
+				{Path: "foo", Action: Skip},
+				{Path: "xyzzy", Action: Skip},
+				{Path: "bar", Action: Merge},
+				{Path: "foo", Action: Merge},
+				{Path: "baz", Action: Merge},
+				{Path: "plugh", Action: LeftToRight},
+			},
+			changes: []OverrideChange{
+				{"foo", NoAction, Merge, nil, nil},
+				{"bar", RightToLeft, Merge, nil, nil},
+			},
+			messages: []Message{
+				{"These items are not files on both sides, so they cannot be merged:\nbaz", Warning},
+				{"These items are not in the plan, so the decisions about them were ignored:\nxyzzy\nplugh", Info},
+			},
+		},
+		{
+			name: lineno(),
+			decisions: []Decision{
+				{
+					Path:   "foo",
+					Action: RightToLeft,
+					Left:   ExportedContent{"file", "modified", "size 2"},
+					Right:  ExportedContent{"file", "unchanged", "size 3"},
+				},
+				{
+					Path:   "bar",
+					Action: Skip,
+					Left:   ExportedContent{"file", "modified", "size 2"},
+					Right:  ExportedContent{"file", "modified", "size 4"},
+				},
+			},
+			changes: []OverrideChange{
+				{"foo", NoAction, RightToLeft, nil, nil},
+			},
+			messages: []Message{
+				{"These items have changed since the decisions were made, so they were not applied:\nbar", Warning},
+			},
+		},
+		{
+			name: lineno(),
+			decisions: []Decision{
+				{Path: "foo", Action: Skip},
+				{Path: "bar", Action: LeftToRight},
+				{Path: "bar", Action: RightToLeft}, // back to what it was
+			},
+			changes: []OverrideChange{
+				{"foo", NoAction, Skip, nil, nil},
+			},
+		},
+		{
+			name: lineno(),
+			decisions: []Decision{
+				{Path: "quux", Action: Skip}, // as the rule has set, but now it's the user's decision
+			},
+			changes: []OverrideChange{
+				{"quux", Skip, Skip, rule, nil},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			changes, messages := ResolveDecisions(items, c.decisions)
+			assertEqual(t, changes, c.changes)
+			assertEqual(t, messages, c.messages)
+		})
+	}
+}
+
+// TestExportImport checks that importing an exported plan into the same plan
+// restores the overrides it had when exported.
+func TestExportImport(t *testing.T) {
+	items := []Item{
+		item("foo", Modified, "size 2", LeftToRight, Unchanged, "size 3", Skip),
+		item("bar", Modified, "size 2", Skip, Modified, "size 3", Merge),
+		item("baz", Directory, Modified, "", LeftToRight, Directory, Unchanged, ""),
+		item("qux", Absent, Deleted, "", LeftToRight, Modified, "size 5", RightToLeft),
+	}
+	var buf bytes.Buffer
+	require.NoError(t, ExportPlan(&buf, ExportJSON, &Core{Items: items}))
+	decisions, err := ReadDecisions(&buf)
+	require.NoError(t, err)
+
+	reset := make([]Item, len(items))
+	copy(reset, items)
+	for i := range reset {
+		reset[i].Override = NoAction
+	}
+	changes, messages := ResolveDecisions(reset, decisions)
+	assert.Empty(t, messages)
+	for _, change := range changes {
+		for i := range reset {
+			if reset[i].Path == change.Path {
+				reset[i].Override = change.New
+			}
+		}
+	}
+	assertEqual(t, reset, items)
+}
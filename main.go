@@ -15,14 +15,17 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/gotk3/gotk3/gdk"
 	"github.com/gotk3/gotk3/glib"
 	"github.com/gotk3/gotk3/gtk"
-	"github.com/skratchdot/open-golang/open"
+	"github.com/vfaronov/gunison/prf"
 )
 
 var (
 	core = NewCore()
 
+	unisonArgs  []string // with which Unison was last started, minus -dumbtty
+	fullArgs    []string // unisonArgs before "Rescan only these" restricted them (if it did)
 	unison      *exec.Cmd
 	unisonR     io.ReadCloser
 	unisonW     io.WriteCloser
@@ -36,6 +39,15 @@ var (
 	infobar             *gtk.InfoBar
 	infobarLabel        *gtk.Label
 	scrolledWindow      *gtk.ScrolledWindow
+	profilePicker       *gtk.ScrolledWindow
+	profileView         *gtk.TreeView
+	profileSelection    *gtk.TreeSelection
+	profileStore        *gtk.ListStore
+	filterBox           *gtk.Box
+	filterEntry         *gtk.SearchEntry
+	filterOnlyCombo     *gtk.ComboBoxText
+	filterStatusCombo   *gtk.ComboBoxText
+	filterTypeCombo     *gtk.ComboBoxText
 	treeview            *gtk.TreeView
 	treeSelection       *gtk.TreeSelection
 	treestore           *gtk.TreeStore
@@ -43,14 +55,31 @@ var (
 	leftColumn          *gtk.TreeViewColumn
 	actionColumn        *gtk.TreeViewColumn
 	rightColumn         *gtk.TreeViewColumn
+	resultColumn        *gtk.TreeViewColumn
+	leftSizeColumn      *gtk.TreeViewColumn
+	leftModTimeColumn   *gtk.TreeViewColumn
+	rightSizeColumn     *gtk.TreeViewColumn
+	rightModTimeColumn  *gtk.TreeViewColumn
+	permsColumn         *gtk.TreeViewColumn
+	typeColumn          *gtk.TreeViewColumn
 	columns             []*gtk.TreeViewColumn
+	summaryLabel        *gtk.Label
 	itemMenu            *gtk.Menu
 	leftToRightMenuItem *gtk.MenuItem
 	rightToLeftMenuItem *gtk.MenuItem
 	mergeMenuItem       *gtk.MenuItem
 	skipMenuItem        *gtk.MenuItem
 	revertMenuItem      *gtk.MenuItem
+	undoMenuItem        *gtk.MenuItem
+	redoMenuItem        *gtk.MenuItem
 	diffMenuItem        *gtk.MenuItem
+	rescanMenuItem      *gtk.MenuItem
+	rescanAllMenuItem   *gtk.MenuItem
+	ignorePathMenuItem  *gtk.MenuItem
+	ignoreNameMenuItem  *gtk.MenuItem
+	ignoreExtMenuItem   *gtk.MenuItem
+	exportMenuItem      *gtk.MenuItem
+	importMenuItem      *gtk.MenuItem
 	squashMenuItem      *gtk.CheckMenuItem
 	statusLabel         *gtk.Label
 	spinner             *gtk.Spinner
@@ -59,9 +88,41 @@ var (
 	abortButton         *gtk.Button
 	killButton          *gtk.Button
 	closeButton         *gtk.Button
-
-	messages = []Message{}
-	wantQuit bool
+	retryButton         *gtk.Button
+	rescanButton        *gtk.Button
+	openProfileButton   *gtk.Button
+	newSessionButton    *gtk.Button
+	sessionDialog       *gtk.Dialog
+	leftRootEntry       *gtk.Entry
+	rightRootEntry      *gtk.Entry
+	preferCombo         *gtk.ComboBoxText
+	timesCheck          *gtk.CheckButton
+	permsCheck          *gtk.CheckButton
+	saveProfileCheck    *gtk.CheckButton
+	profileNameEntry    *gtk.Entry
+	sessionErrorLabel   *gtk.Label
+	diffWindow          *gtk.Window
+	diffHeaderbar       *gtk.HeaderBar
+	diffStack           *gtk.Stack
+	diffView            *gtk.TextView
+	diffBuffer          *gtk.TextBuffer
+	diffLeftView        *gtk.TextView
+	diffLeftBuffer      *gtk.TextBuffer
+	diffRightView       *gtk.TextView
+	diffRightBuffer     *gtk.TextBuffer
+	diffSearchEntry     *gtk.SearchEntry
+	prevHunkButton      *gtk.Button
+	nextHunkButton      *gtk.Button
+	saveDiffButton      *gtk.Button
+	diffLeftImage       *gtk.Image
+	diffLeftCaption     *gtk.Label
+	diffRightImage      *gtk.Image
+	diffRightCaption    *gtk.Label
+	shortcutsWindow     *gtk.ShortcutsWindow
+
+	messages   = []Message{}
+	wantQuit   bool
+	customDiff bool // whether Unison's diff preference is set, so that it must be used for diffs
 
 	collapsed = map[string]bool{} // TODO: use a more efficient structure for this, like a trie?
 )
@@ -83,7 +144,9 @@ func main() {
 	setupWidgets()
 	loadUIState()
 	window.Show()
-	startUnison(os.Args[1:]...)
+	if len(os.Args) > 1 || !showProfilePicker() {
+		startUnison(os.Args[1:]...)
+	}
 	log.Println("starting main loop")
 	gtk.Main()
 	// saveUIState is not called here (unlike loadUIState), because it needs the current window size,
@@ -93,7 +156,10 @@ func main() {
 func startUnison(args ...string) {
 	var err error
 
-	args = append(args, "-dumbtty")
+	unisonArgs = args
+	loadRules(args)
+	args = neutralizePrefs(args)
+	args = append(args[:len(args):len(args)], "-dumbtty")
 	unison = exec.Command("unison", args...)
 	unison.SysProcAttr = sysProcAttr
 	unison.Env = DeleteEnv(os.Environ(), "PAGER") // otherwise Unison pipes e.g. diff output through it
@@ -123,6 +189,202 @@ func startUnison(args ...string) {
 	update(core.ProcStart())
 }
 
+// neutralizePrefs returns args with overrides for any preferences that would break Gunison,
+// and tells the user about them.
+func neutralizePrefs(args []string) []string {
+	var profile *prf.File
+	if dir, err := ProfileDir(); shouldf(err, "find profile directory") {
+		profile, err = prf.Load(dir, ProfileArg(args, dir))
+		if !errors.Is(err, os.ErrNotExist) {
+			shouldf(err, "load profile")
+		}
+	}
+	customDiff = PrefIsSet(args, profile, "diff")
+	args, overridden := NeutralizePrefs(args, profile)
+	if overridden != nil {
+		messages = append(messages, Message{
+			"These Unison preferences would break Gunison, so they have been overridden: " +
+				strings.Join(overridden, ", "),
+			Info,
+		})
+	}
+	return args
+}
+
+// loadRules sets core.Rules from the rules file for the profile that Unison will use with args, if any.
+// It is called on every start, so that changes to the file take effect on rescan.
+func loadRules(args []string) {
+	core.Rules = nil
+	dir, err := ProfileDir()
+	if !shouldf(err, "find profile directory") {
+		return
+	}
+	name := rulesPath(ProfileArg(args, dir))
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if !checkf(err, "read rules from %v", name) {
+		return
+	}
+	rules, err := ParseRules(string(data))
+	if !checkf(err, "load rules from %v", name) {
+		return
+	}
+	log.Printf("loaded %d rules from %v", len(rules), name)
+	core.Rules = rules
+}
+
+func rulesPath(profile string) string {
+	base, err := os.UserConfigDir()
+	mustf(err, "get user config dir")
+	return filepath.Join(base, "gunison", "rules", profile+".txt")
+}
+
+// showProfilePicker offers the user to choose one of Unison's profiles to run, or to start a new session.
+// It returns false if profiles can't be listed.
+func showProfilePicker() bool {
+	dir, err := ProfileDir()
+	if !shouldf(err, "find profile directory") {
+		return false
+	}
+	profiles, err := ListProfiles(dir)
+	if !shouldf(err, "list profiles in %s", dir) {
+		return false
+	}
+	for _, profile := range profiles {
+		mustf(profileStore.Set(profileStore.Append(), []int{0, 1, 2}, []interface{}{
+			profile.Name,
+			strings.Join(profile.Roots, " — "),
+			profile.ModTime.Format("2006-01-02 15:04"),
+		}), "add profile %s", profile.Name)
+	}
+	if first, ok := profileStore.GetIterFirst(); ok {
+		profileSelection.SelectIter(first)
+	}
+	scrolledWindow.SetVisible(false)
+	profilePicker.SetVisible(true)
+	openProfileButton.SetVisible(true)
+	openProfileButton.SetSensitive(len(profiles) > 0)
+	newSessionButton.SetVisible(true)
+	spinner.SetVisible(false)
+	statusLabel.SetText("Choose a profile")
+	profileView.GrabFocus()
+	return true
+}
+
+func onProfileViewRowActivated(_ *gtk.TreeView, treepath *gtk.TreePath) {
+	iter, err := profileStore.GetIter(treepath)
+	mustf(err, "get iter for profile %v", treepath)
+	openProfile(iter)
+}
+
+func onOpenProfileButtonClicked() {
+	if _, iter, ok := profileSelection.GetSelected(); ok {
+		openProfile(iter)
+	}
+}
+
+func openProfile(iter *gtk.TreeIter) {
+	startFromPicker(MustGetColumn(profileStore, iter, 0).(string))
+}
+
+func startFromPicker(args ...string) {
+	profilePicker.SetVisible(false)
+	openProfileButton.SetVisible(false)
+	newSessionButton.SetVisible(false)
+	scrolledWindow.SetVisible(true)
+	startUnison(args...)
+}
+
+func onNewSessionButtonClicked() {
+	for {
+		resp := sessionDialog.Run()
+		if resp != gtk.RESPONSE_OK {
+			sessionDialog.Hide()
+			return
+		}
+		args, err := newSession()
+		if err != nil {
+			sessionErrorLabel.SetText(err.Error())
+			sessionErrorLabel.SetVisible(true)
+			continue
+		}
+		sessionDialog.Hide()
+		startFromPicker(args...)
+		return
+	}
+}
+
+// newSession returns Unison arguments for the session set up in sessionDialog,
+// saving it as a profile if requested.
+func newSession() ([]string, error) {
+	var s Session
+	var err error
+	for i, entry := range []*gtk.Entry{leftRootEntry, rightRootEntry} {
+		s.Roots[i], err = entry.GetText()
+		mustf(err, "get root text")
+		s.Roots[i] = strings.TrimSpace(s.Roots[i])
+	}
+	if s.Prefer = preferCombo.GetActiveID(); s.Prefer == "none" {
+		s.Prefer = ""
+	}
+	s.Times = timesCheck.GetActive()
+	s.Perms = permsCheck.GetActive()
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	if !saveProfileCheck.GetActive() {
+		return s.Args(), nil
+	}
+	name, err := profileNameEntry.GetText()
+	mustf(err, "get profile name text")
+	dir, err := ProfileDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.SaveProfile(dir, name); err != nil {
+		return nil, err
+	}
+	return []string{name}, nil
+}
+
+func onLeftRootButtonClicked()  { chooseFolder(leftRootEntry) }
+func onRightRootButtonClicked() { chooseFolder(rightRootEntry) }
+
+func onSaveProfileCheckToggled() {
+	profileNameEntry.SetSensitive(saveProfileCheck.GetActive())
+}
+
+func chooseFolder(entry *gtk.Entry) {
+	chooser, err := gtk.FileChooserNativeDialogNew("Choose a folder", sessionDialog,
+		gtk.FILE_CHOOSER_ACTION_SELECT_FOLDER, "_Choose", "_Cancel")
+	if !shouldf(err, "create file chooser") {
+		return
+	}
+	defer chooser.Destroy()
+	if current, _ := entry.GetText(); current != "" && !strings.Contains(current, "://") {
+		chooser.SetCurrentFolder(current)
+	}
+	if chooser.Run() == int(gtk.RESPONSE_ACCEPT) {
+		entry.SetText(chooser.GetFilename())
+	}
+}
+
+// restartUnison starts a new Unison process after the previous one has exited,
+// resetting the UI to its initial state. The core carries over the user's overrides.
+func restartUnison(args ...string) {
+	messages = messages[:0]
+	treeview.SetVisible(false)
+	filterBox.SetVisible(false)
+	summaryLabel.SetVisible(false)
+	treestore.Clear()
+	resultColumn.SetVisible(false)
+	setSort(sortRule{})
+	history = History{} // the new plan may not match the recorded changes
+	startUnison(args...)
+}
+
 func watchUnison() {
 	var buf [65536]byte // has to be rather large due to https://github.com/vfaronov/gunison/issues/1
 	for {
@@ -160,6 +422,7 @@ func setupWidgets() {
 
 	window = mustGetObject(builder, "window").(*gtk.Window)
 	window.Connect("delete-event", onWindowDeleteEvent)
+	window.Connect("key-press-event", onWindowKeyPressEvent)
 	window.Connect("destroy", gtk.MainQuit)
 
 	infobar = mustGetObject(builder, "infobar").(*gtk.InfoBar)
@@ -171,12 +434,30 @@ func setupWidgets() {
 
 	scrolledWindow = mustGetObject(builder, "scrolled-window").(*gtk.ScrolledWindow)
 
+	profilePicker = mustGetObject(builder, "profiles-scrolled-window").(*gtk.ScrolledWindow)
+	profileView = mustGetObject(builder, "profiles-treeview").(*gtk.TreeView)
+	profileView.Connect("row-activated", onProfileViewRowActivated)
+	profileSelection = mustGetObject(builder, "profile-selection").(*gtk.TreeSelection)
+	profileStore = mustGetObject(builder, "profile-store").(*gtk.ListStore)
+
+	filterBox = mustGetObject(builder, "filter-box").(*gtk.Box)
+	filterEntry = mustGetObject(builder, "filter-entry").(*gtk.SearchEntry)
+	filterEntry.Connect("search-changed", onFilterChanged)
+	filterEntry.Connect("stop-search", onFilterEntryStopSearch)
+	filterOnlyCombo = mustGetObject(builder, "filter-only-combo").(*gtk.ComboBoxText)
+	filterOnlyCombo.Connect("changed", onFilterChanged)
+	filterStatusCombo = mustGetObject(builder, "filter-status-combo").(*gtk.ComboBoxText)
+	filterStatusCombo.Connect("changed", onFilterChanged)
+	filterTypeCombo = mustGetObject(builder, "filter-type-combo").(*gtk.ComboBoxText)
+	filterTypeCombo.Connect("changed", onFilterChanged)
+
 	treeview = mustGetObject(builder, "treeview").(*gtk.TreeView)
 	treeview.Connect("popup-menu", onTreeviewPopupMenu)
 	treeview.Connect("button-press-event", onTreeviewButtonPressEvent)
 	treeview.Connect("query-tooltip", onTreeviewQueryTooltip)
 	treeview.Connect("row-expanded", onTreeviewRowExpanded)
 	treeview.Connect("row-collapsed", onTreeviewRowCollapsed)
+	treeview.Connect("key-press-event", onTreeviewKeyPressEvent)
 
 	treeSelection = mustGetObject(builder, "tree-selection").(*gtk.TreeSelection)
 	treeSelection.Connect("changed", onTreeSelectionChanged)
@@ -185,9 +466,24 @@ func setupWidgets() {
 	pathColumn = mustGetObject(builder, "path-column").(*gtk.TreeViewColumn)
 	pathColumn.Connect("clicked", onPathColumnClicked)
 	leftColumn = mustGetObject(builder, "left-column").(*gtk.TreeViewColumn)
+	leftColumn.Connect("clicked", onLeftColumnClicked)
 	actionColumn = mustGetObject(builder, "action-column").(*gtk.TreeViewColumn)
 	actionColumn.Connect("clicked", onActionColumnClicked)
 	rightColumn = mustGetObject(builder, "right-column").(*gtk.TreeViewColumn)
+	rightColumn.Connect("clicked", onRightColumnClicked)
+	resultColumn = mustGetObject(builder, "result-column").(*gtk.TreeViewColumn)
+	leftSizeColumn = mustGetObject(builder, "left-size-column").(*gtk.TreeViewColumn)
+	leftSizeColumn.Connect("clicked", onLeftSizeColumnClicked)
+	leftModTimeColumn = mustGetObject(builder, "left-mtime-column").(*gtk.TreeViewColumn)
+	leftModTimeColumn.Connect("clicked", onLeftModTimeColumnClicked)
+	rightSizeColumn = mustGetObject(builder, "right-size-column").(*gtk.TreeViewColumn)
+	rightSizeColumn.Connect("clicked", onRightSizeColumnClicked)
+	rightModTimeColumn = mustGetObject(builder, "right-mtime-column").(*gtk.TreeViewColumn)
+	rightModTimeColumn.Connect("clicked", onRightModTimeColumnClicked)
+	permsColumn = mustGetObject(builder, "perms-column").(*gtk.TreeViewColumn)
+	typeColumn = mustGetObject(builder, "type-column").(*gtk.TreeViewColumn)
+	typeColumn.Connect("clicked", onTypeColumnClicked)
+	summaryLabel = mustGetObject(builder, "summary-label").(*gtk.Label)
 	// Pin down the original order of columns (before the user reorders them) for loadUIState/saveUIState.
 	for li, next := Iter(treeview.GetColumns()); li != nil; li = next() {
 		columns = append(columns, li.Data().(*gtk.TreeViewColumn))
@@ -204,16 +500,49 @@ func setupWidgets() {
 	skipMenuItem.Connect("activate", onSkipMenuItemActivate)
 	revertMenuItem = mustGetObject(builder, "revert-menuitem").(*gtk.MenuItem)
 	revertMenuItem.Connect("activate", onRevertMenuItemActivate)
+	undoMenuItem = mustGetObject(builder, "undo-menuitem").(*gtk.MenuItem)
+	undoMenuItem.Connect("activate", onUndoMenuItemActivate)
+	redoMenuItem = mustGetObject(builder, "redo-menuitem").(*gtk.MenuItem)
+	redoMenuItem.Connect("activate", onRedoMenuItemActivate)
 	diffMenuItem = mustGetObject(builder, "diff-menuitem").(*gtk.MenuItem)
 	diffMenuItem.Connect("activate", onDiffMenuItemActivate)
+	rescanMenuItem = mustGetObject(builder, "rescan-menuitem").(*gtk.MenuItem)
+	rescanMenuItem.Connect("activate", onRescanMenuItemActivate)
+	rescanAllMenuItem = mustGetObject(builder, "rescan-all-menuitem").(*gtk.MenuItem)
+	rescanAllMenuItem.Connect("activate", onRescanAllMenuItemActivate)
+	ignorePathMenuItem = mustGetObject(builder, "ignore-path-menuitem").(*gtk.MenuItem)
+	ignorePathMenuItem.Connect("activate", onIgnorePathMenuItemActivate)
+	ignoreNameMenuItem = mustGetObject(builder, "ignore-name-menuitem").(*gtk.MenuItem)
+	ignoreNameMenuItem.Connect("activate", onIgnoreNameMenuItemActivate)
+	ignoreExtMenuItem = mustGetObject(builder, "ignore-ext-menuitem").(*gtk.MenuItem)
+	ignoreExtMenuItem.Connect("activate", onIgnoreExtMenuItemActivate)
+	exportMenuItem = mustGetObject(builder, "export-menuitem").(*gtk.MenuItem)
+	exportMenuItem.Connect("activate", onExportMenuItemActivate)
+	importMenuItem = mustGetObject(builder, "import-menuitem").(*gtk.MenuItem)
+	importMenuItem.Connect("activate", onImportMenuItemActivate)
 	squashMenuItem = mustGetObject(builder, "squash-menuitem").(*gtk.CheckMenuItem)
 	onSquashMenuItemToggledHandle = squashMenuItem.Connect("toggled", onSquashMenuItemToggled)
+	mustGetObject(builder, "shortcuts-menuitem").(*gtk.MenuItem).Connect("activate", onShortcutsMenuItemActivate)
+	optionalColumns = []optionalColumn{
+		{leftSizeColumn, mustGetObject(builder, "left-size-menuitem").(*gtk.CheckMenuItem)},
+		{leftModTimeColumn, mustGetObject(builder, "left-mtime-menuitem").(*gtk.CheckMenuItem)},
+		{rightSizeColumn, mustGetObject(builder, "right-size-menuitem").(*gtk.CheckMenuItem)},
+		{rightModTimeColumn, mustGetObject(builder, "right-mtime-menuitem").(*gtk.CheckMenuItem)},
+		{permsColumn, mustGetObject(builder, "perms-menuitem").(*gtk.CheckMenuItem)},
+		{typeColumn, mustGetObject(builder, "type-menuitem").(*gtk.CheckMenuItem)},
+	}
+	for _, opt := range optionalColumns {
+		opt := opt
+		opt.menuItem.Connect("toggled", func() { opt.column.SetVisible(opt.menuItem.GetActive()) })
+	}
 
 	// For some reason GTK/Glade think xalign has a default of 0.5, so Glade optimizes it away from
 	// the XML file upon saving.
 	mustf(mustGetObject(builder, "left-renderer").(*gtk.CellRendererText).Set("xalign", 0.5), "set xalign")
 	mustf(mustGetObject(builder, "action-renderer").(*gtk.CellRendererText).Set("xalign", 0.5), "set xalign")
 	mustf(mustGetObject(builder, "right-renderer").(*gtk.CellRendererText).Set("xalign", 0.5), "set xalign")
+	mustf(mustGetObject(builder, "result-renderer").(*gtk.CellRendererText).Set("xalign", 0.5), "set xalign")
+	mustf(mustGetObject(builder, "perms-renderer").(*gtk.CellRendererText).Set("xalign", 0.5), "set xalign")
 
 	statusLabel = mustGetObject(builder, "status-label").(*gtk.Label)
 
@@ -233,9 +562,67 @@ func setupWidgets() {
 	closeButton = mustGetObject(builder, "close-button").(*gtk.Button)
 	closeButton.Connect("clicked", exit)
 
+	retryButton = mustGetObject(builder, "retry-button").(*gtk.Button)
+	retryButton.Connect("clicked", onRetryButtonClicked)
+	rescanButton = mustGetObject(builder, "rescan-button").(*gtk.Button)
+	rescanButton.Connect("clicked", onRescanButtonClicked)
+	openProfileButton = mustGetObject(builder, "open-profile-button").(*gtk.Button)
+	openProfileButton.Connect("clicked", onOpenProfileButtonClicked)
+	newSessionButton = mustGetObject(builder, "new-session-button").(*gtk.Button)
+	newSessionButton.Connect("clicked", onNewSessionButtonClicked)
+
+	sessionDialog = mustGetObject(builder, "session-dialog").(*gtk.Dialog)
+	leftRootEntry = mustGetObject(builder, "left-root-entry").(*gtk.Entry)
+	rightRootEntry = mustGetObject(builder, "right-root-entry").(*gtk.Entry)
+	mustGetObject(builder, "left-root-button").(*gtk.Button).Connect("clicked", onLeftRootButtonClicked)
+	mustGetObject(builder, "right-root-button").(*gtk.Button).Connect("clicked", onRightRootButtonClicked)
+	preferCombo = mustGetObject(builder, "prefer-combo").(*gtk.ComboBoxText)
+	timesCheck = mustGetObject(builder, "times-check").(*gtk.CheckButton)
+	permsCheck = mustGetObject(builder, "perms-check").(*gtk.CheckButton)
+	saveProfileCheck = mustGetObject(builder, "save-profile-check").(*gtk.CheckButton)
+	saveProfileCheck.Connect("toggled", onSaveProfileCheckToggled)
+	profileNameEntry = mustGetObject(builder, "profile-name-entry").(*gtk.Entry)
+	sessionErrorLabel = mustGetObject(builder, "session-error-label").(*gtk.Label)
+
+	shortcutsWindow = mustGetObject(builder, "shortcuts-window").(*gtk.ShortcutsWindow)
+	shortcutsWindow.Connect("delete-event", onShortcutsWindowDeleteEvent)
+
+	diffWindow = mustGetObject(builder, "diff-window").(*gtk.Window)
+	diffWindow.Connect("delete-event", onDiffWindowDeleteEvent)
+	diffWindow.Connect("key-press-event", onDiffWindowKeyPressEvent)
+	diffHeaderbar = mustGetObject(builder, "diff-headerbar").(*gtk.HeaderBar)
+	diffStack = mustGetObject(builder, "diff-stack").(*gtk.Stack)
+	diffView, diffBuffer = setupDiffPane(builder, "diff-textview")
+	diffLeftView, diffLeftBuffer = setupDiffPane(builder, "diff-left-textview")
+	diffRightView, diffRightBuffer = setupDiffPane(builder, "diff-right-textview")
+	diffSearchEntry = mustGetObject(builder, "diff-search-entry").(*gtk.SearchEntry)
+	diffSearchEntry.Connect("search-changed", onDiffSearchEntrySearchChanged)
+	diffSearchEntry.Connect("activate", onDiffSearchEntryNextMatch)
+	diffSearchEntry.Connect("next-match", onDiffSearchEntryNextMatch)
+	diffSearchEntry.Connect("previous-match", onDiffSearchEntryPreviousMatch)
+	prevHunkButton = mustGetObject(builder, "prev-hunk-button").(*gtk.Button)
+	prevHunkButton.Connect("clicked", onPrevHunkButtonClicked)
+	nextHunkButton = mustGetObject(builder, "next-hunk-button").(*gtk.Button)
+	nextHunkButton.Connect("clicked", onNextHunkButtonClicked)
+	saveDiffButton = mustGetObject(builder, "save-diff-button").(*gtk.Button)
+	saveDiffButton.Connect("clicked", onSaveDiffButtonClicked)
+	diffLeftImage = mustGetObject(builder, "diff-left-image").(*gtk.Image)
+	diffLeftCaption = mustGetObject(builder, "diff-left-caption").(*gtk.Label)
+	diffRightImage = mustGetObject(builder, "diff-right-image").(*gtk.Image)
+	diffRightCaption = mustGetObject(builder, "diff-right-caption").(*gtk.Label)
+
 	update(Update{})
 }
 
+func setupDiffPane(builder *gtk.Builder, name string) (*gtk.TextView, *gtk.TextBuffer) {
+	view := mustGetObject(builder, name).(*gtk.TextView)
+	view.Connect("focus-in-event", onDiffPaneFocusInEvent)
+	buffer, err := view.GetBuffer()
+	mustf(err, "get text buffer of %s", name)
+	setupDiffTags(buffer)
+	return view, buffer
+}
+
 func recvOutput(d []byte) {
 	log.Printf("processing Unison output: %d bytes", len(d))
 	update(core.ProcOutput(d))
@@ -265,8 +652,14 @@ func update(upd Update) {
 		return
 	}
 
-	if upd.Diff != nil {
-		displayDiff(upd.Diff)
+	if upd.DiffChunk != nil {
+		if !diffReceiving {
+			beginDiff()
+		}
+		appendDiff(upd.DiffChunk)
+	}
+	if upd.DiffDone {
+		endDiff()
 	}
 
 	if core.Left != "" && core.Right != "" {
@@ -274,12 +667,25 @@ func update(upd Update) {
 	}
 
 	if core.Items != nil && !treeview.GetVisible() {
+		recordPlanOrder()
 		displayItems()
 		treeview.SetVisible(true)
+		filterBox.SetVisible(true)
+		summaryLabel.SetVisible(true)
 		treeview.GrabFocus()
 		shouldf(ClearCursor(treeview), "clear treeview cursor")
 	}
 
+	if upd.Replanned && treeview.GetVisible() {
+		PreserveScroll(scrolledWindow.GetVAdjustment())
+		displayItems()
+	}
+
+	if len(core.Items) > 0 && core.Items[0].Result.Outcome != NoOutcome { // synchronization has started
+		resultColumn.SetVisible(true)
+		displayResults()
+	}
+
 	updateMenuItems()
 
 	spinner.SetVisible(core.Busy)
@@ -298,6 +704,8 @@ func update(upd Update) {
 	syncButton.SetVisible(core.Sync != nil)
 	abortButton.SetVisible(core.Abort != nil)
 	closeButton.SetVisible(!core.Running)
+	retryButton.SetVisible(len(core.RetryPaths) > 0)
+	rescanButton.SetVisible(core.Rescan != nil)
 	if closeButton.GetVisible() {
 		closeButton.GrabFocus()
 	}
@@ -333,6 +741,11 @@ func update(upd Update) {
 		}
 	}
 
+	if upd.Restart {
+		restartUnison(unisonArgs...)
+		return
+	}
+
 	// This goes last because we better update everything before showing the dialog
 	// (which itself will, moreover, trigger another update).
 	if upd.Alert.Text != "" {
@@ -413,17 +826,23 @@ var importanceToMessageType = map[Importance]gtk.MessageType{
 	Error:   gtk.MESSAGE_ERROR,
 }
 
-func displayDiff(diff []byte) {
-	f, err := os.CreateTemp("", "gunison-*.diff")
-	if !checkf(err, "write diff to temporary file") {
-		return
-	}
-	_, err = f.Write(diff)
-	if !checkf(err, "write diff to temporary file") {
-		return
+func onWindowKeyPressEvent(_ *gtk.Window, ev *gdk.Event) bool {
+	evk := gdk.EventKeyNewFromEvent(ev)
+	mods := gdk.ModifierType(evk.State()) & (gdk.CONTROL_MASK | gdk.MOD1_MASK)
+	if mods == gdk.CONTROL_MASK && evk.KeyVal() == gdk.KEY_question {
+		shortcutsWindow.ShowAll()
+		return blockDefault
 	}
-	name := f.Name()
-	checkf(open.Start(name), "display diff file: %v", name)
+	return handleDefault
+}
+
+func onShortcutsMenuItemActivate() {
+	shortcutsWindow.ShowAll()
+}
+
+func onShortcutsWindowDeleteEvent() bool {
+	shortcutsWindow.Hide()
+	return blockDefault // keep the window around for the next time
 }
 
 func onWindowDeleteEvent() bool {
@@ -483,12 +902,27 @@ func onKillButtonClicked() {
 	invokeUpdate(core.Kill)
 }
 
+func onRescanButtonClicked() {
+	invokeUpdate(core.Rescan)
+}
+
+func onRetryButtonClicked() {
+	for _, path := range core.RetryPaths {
+		if path == "" { // entire replica, so there's nothing to restrict
+			restartUnison(unisonArgs...)
+			return
+		}
+	}
+	restartUnison(ReplacePaths(unisonArgs, core.RetryPaths...)...)
+}
+
 type uiState struct {
 	Squash        bool
 	Width, Height int
 	Maximized     bool
-	ColumnOrder   []int // indices match var columns
-	ColumnWidth   []int // indices match var columns
+	ColumnOrder   []int  // indices match var columns
+	ColumnWidth   []int  // indices match var columns
+	ColumnVisible []bool // indices match var columns; only matters for optional columns
 	Collapsed     []string
 }
 
@@ -516,8 +950,9 @@ func loadUIState() {
 		return
 	}
 
-	log.Printf("state: Squash:%v Width:%v Height:%v Maximized:%v ColumnOrder:%v ColumnWidth:%v",
-		state.Squash, state.Width, state.Height, state.Maximized, state.ColumnOrder, state.ColumnWidth)
+	log.Printf("state: Squash:%v Width:%v Height:%v Maximized:%v ColumnOrder:%v ColumnWidth:%v ColumnVisible:%v",
+		state.Squash, state.Width, state.Height, state.Maximized, state.ColumnOrder, state.ColumnWidth,
+		state.ColumnVisible)
 
 	squash = state.Squash
 
@@ -539,7 +974,15 @@ func loadUIState() {
 	}
 
 	for i, column := range columns {
-		column.SetFixedWidth(state.ColumnWidth[i])
+		if i < len(state.ColumnWidth) { // columns added in later versions are missing from older state
+			column.SetFixedWidth(state.ColumnWidth[i])
+		}
+	}
+
+	for i, column := range columns {
+		if i < len(state.ColumnVisible) && isOptionalColumn(column) { // others are shown automatically
+			column.SetVisible(state.ColumnVisible[i])
+		}
 	}
 
 	collapsed = make(map[string]bool, len(state.Collapsed))
@@ -589,6 +1032,7 @@ func saveUIState() {
 			width = column.GetFixedWidth()
 		}
 		state.ColumnWidth = append(state.ColumnWidth, width)
+		state.ColumnVisible = append(state.ColumnVisible, column.GetVisible())
 	}
 
 	state.Collapsed = make([]string, 0, len(collapsed))
@@ -597,8 +1041,9 @@ func saveUIState() {
 	}
 	sort.Strings(state.Collapsed)
 
-	log.Printf("state: Squash:%v Width:%v Height:%v Maximized:%v ColumnOrder:%v ColumnWidth:%v",
-		state.Squash, state.Width, state.Height, state.Maximized, state.ColumnOrder, state.ColumnWidth)
+	log.Printf("state: Squash:%v Width:%v Height:%v Maximized:%v ColumnOrder:%v ColumnWidth:%v ColumnVisible:%v",
+		state.Squash, state.Width, state.Height, state.Maximized, state.ColumnOrder, state.ColumnWidth,
+		state.ColumnVisible)
 
 	enc := json.NewEncoder(f)
 	enc.SetIndent("", "  ")
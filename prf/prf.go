@@ -0,0 +1,320 @@
+// Package prf reads and writes Unison profiles (.prf files). It preserves the formatting
+// and comments of the original file, so that changes can be written back without surprises
+// for the user who maintains the profile by hand.
+package prf
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A File is the contents of one profile file, or of a file included from it.
+type File struct {
+	Name  string // the file name as it was loaded, or empty if the File was parsed from elsewhere
+	Lines []*Line
+}
+
+// A Line is one line of a profile file.
+type Line struct {
+	Kind     Kind
+	Name     string // of the preference (for Pref)
+	Value    string // of the preference (for Pref), or the name of the included file (for Include, Source)
+	Optional bool   // for Include and Source, whether it's "include?" or "source?"
+	Included *File  // for Include and Source, as loaded by Load (nil if Optional and missing)
+
+	raw        string // original text, including the line terminator (if any)
+	valueStart int    // offset of Value in raw
+	valueEnd   int
+}
+
+// Kind distinguishes lines by their syntax.
+type Kind byte
+
+const (
+	Blank   Kind = iota
+	Comment      // starts with #
+	Pref         // name = value
+	Include      // include name (relative to the Unison directory)
+	Source       // source path (absolute or relative to the Unison directory)
+	Invalid      // anything else, which Unison will probably complain about
+)
+
+// Parse reads a profile from r without loading any included files.
+func Parse(r io.Reader) (*File, error) {
+	f := &File{}
+	br := bufio.NewReader(r)
+	for {
+		raw, err := br.ReadString('\n')
+		if raw != "" {
+			f.Lines = append(f.Lines, parseLine(raw))
+		}
+		if errors.Is(err, io.EOF) {
+			return f, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func parseLine(raw string) *Line {
+	l := &Line{raw: raw}
+	text := strings.TrimRight(raw, "\r\n")
+	trimmed := strings.TrimSpace(text)
+	switch {
+	case trimmed == "":
+		l.Kind = Blank
+		return l
+	case strings.HasPrefix(trimmed, "#"):
+		l.Kind = Comment
+		return l
+	}
+
+	for _, directive := range []struct {
+		word string
+		kind Kind
+	}{{"include", Include}, {"source", Source}} {
+		rest := strings.TrimLeft(text, " \t")
+		if !strings.HasPrefix(rest, directive.word) {
+			continue
+		}
+		rest = rest[len(directive.word):]
+		optional := strings.HasPrefix(rest, "?")
+		if optional {
+			rest = rest[1:]
+		}
+		if rest == "" || (rest[0] != ' ' && rest[0] != '\t') {
+			continue // some preference that happens to begin with the same word
+		}
+		l.Kind = directive.kind
+		l.Optional = optional
+		l.setValueSpan(text, len(text)-len(strings.TrimLeft(rest, " \t")), len(strings.TrimRight(text, " \t")))
+		return l
+	}
+
+	eq := strings.IndexByte(text, '=')
+	if eq == -1 {
+		l.Kind = Invalid
+		return l
+	}
+	l.Kind = Pref
+	l.Name = strings.TrimSpace(text[:eq])
+	start := eq + 1
+	for start < len(text) && (text[start] == ' ' || text[start] == '\t') {
+		start++
+	}
+	end := len(strings.TrimRight(text, " \t"))
+	if end < start {
+		end = start
+	}
+	l.setValueSpan(text, start, end)
+	return l
+}
+
+func (l *Line) setValueSpan(text string, start, end int) {
+	l.valueStart, l.valueEnd = start, end
+	l.Value = text[start:end]
+}
+
+// String returns the line as it will be written, without the line terminator.
+func (l *Line) String() string {
+	return strings.TrimRight(l.raw, "\r\n")
+}
+
+// SetValue changes the Value of a Pref, Include or Source line, keeping the rest of its formatting.
+func (l *Line) SetValue(value string) {
+	l.raw = l.raw[:l.valueStart] + value + l.raw[l.valueEnd:]
+	l.valueEnd = l.valueStart + len(value)
+	l.Value = value
+}
+
+// Bytes returns the contents of f as they should be written to its file.
+func (f *File) Bytes() []byte {
+	var b bytes.Buffer
+	for _, l := range f.Lines {
+		b.WriteString(l.raw)
+	}
+	return b.Bytes()
+}
+
+// Save writes f back to the file it was loaded from.
+func (f *File) Save() error {
+	if f.Name == "" {
+		return errors.New("Profile was not loaded from a file.")
+	}
+	info, err := os.Stat(f.Name)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(f.Name, f.Bytes(), info.Mode().Perm())
+}
+
+// newline returns the line terminator used in f.
+func (f *File) newline() string {
+	for _, l := range f.Lines {
+		if strings.HasSuffix(l.raw, "\r\n") {
+			return "\r\n"
+		}
+		if strings.HasSuffix(l.raw, "\n") {
+			return "\n"
+		}
+	}
+	return "\n"
+}
+
+// Add appends a new preference line to f, returning it.
+func (f *File) Add(name, value string) *Line {
+	nl := f.newline()
+	if n := len(f.Lines); n > 0 && !strings.HasSuffix(f.Lines[n-1].raw, "\n") {
+		f.Lines[n-1].raw += nl
+	}
+	l := parseLine(name + " = " + value + nl)
+	f.Lines = append(f.Lines, l)
+	return l
+}
+
+// Set changes the value of the last preference line with the given name in f, or adds a new line
+// if there is none. This is appropriate for preferences that take a single value.
+func (f *File) Set(name, value string) {
+	for i := len(f.Lines) - 1; i >= 0; i-- {
+		if l := f.Lines[i]; l.Kind == Pref && l.Name == name {
+			l.SetValue(value)
+			return
+		}
+	}
+	f.Add(name, value)
+}
+
+// Remove deletes all preference lines in f with the given name and value, and returns
+// how many lines were deleted.
+func (f *File) Remove(name, value string) int {
+	kept := f.Lines[:0]
+	n := 0
+	for _, l := range f.Lines {
+		if l.Kind == Pref && l.Name == name && l.Value == value {
+			n++
+			continue
+		}
+		kept = append(kept, l)
+	}
+	f.Lines = kept
+	return n
+}
+
+// Prefs calls fn for each preference line in f and the files it includes, in the order
+// in which Unison reads them, until fn returns false.
+func (f *File) Prefs(fn func(*Line) bool) bool {
+	for _, l := range f.Lines {
+		switch l.Kind {
+		case Pref:
+			if !fn(l) {
+				return false
+			}
+		case Include, Source:
+			if l.Included != nil && !l.Included.Prefs(fn) {
+				return false
+			}
+		case Blank, Comment, Invalid:
+		}
+	}
+	return true
+}
+
+// Values returns all values of the preference with the given name, in f and the files it includes.
+// For preferences that take a single value, the last one is in effect.
+func (f *File) Values(name string) []string {
+	var values []string
+	f.Prefs(func(l *Line) bool {
+		if l.Name == name {
+			values = append(values, l.Value)
+		}
+		return true
+	})
+	return values
+}
+
+// Value returns the value of the preference with the given name that is in effect, if any.
+func (f *File) Value(name string) (string, bool) {
+	values := f.Values(name)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// These return the values of the preferences that Gunison is most interested in.
+func (f *File) Roots() []string      { return f.Values("root") }
+func (f *File) Paths() []string      { return f.Values("path") }
+func (f *File) Ignores() []string    { return f.Values("ignore") }
+func (f *File) IgnoreNots() []string { return f.Values("ignorenot") }
+
+// Load reads the profile with the given name (without .prf) from dir, which is Unison's directory,
+// along with all files it includes.
+func Load(dir, name string) (*File, error) {
+	return load(dir, filepath.Join(dir, name+".prf"), nil)
+}
+
+// LoadFile is like Load, but takes the full file name of the profile.
+func LoadFile(dir, filename string) (*File, error) {
+	return load(dir, filename, nil)
+}
+
+func load(dir, filename string, stack []string) (*File, error) {
+	for _, seen := range stack {
+		if seen == filename {
+			return nil, fmt.Errorf("Profile %s includes itself.", filename)
+		}
+	}
+	stack = append(stack, filename)
+
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	f, err := Parse(fh)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %w", filename, err)
+	}
+	f.Name = filename
+
+	for _, l := range f.Lines {
+		var candidates []string
+		switch l.Kind {
+		case Include:
+			// Unison tries the name as is, then with .prf appended.
+			candidates = []string{filepath.Join(dir, l.Value), filepath.Join(dir, l.Value+".prf")}
+		case Source:
+			if filepath.IsAbs(l.Value) {
+				candidates = []string{l.Value}
+			} else {
+				candidates = []string{filepath.Join(dir, l.Value)}
+			}
+		case Blank, Comment, Pref, Invalid:
+			continue
+		}
+		found := ""
+		for _, candidate := range candidates {
+			if _, err := os.Stat(candidate); err == nil {
+				found = candidate
+				break
+			}
+		}
+		if found == "" {
+			if l.Optional {
+				continue
+			}
+			return nil, fmt.Errorf("Cannot find %s included from %s.", l.Value, filename)
+		}
+		if l.Included, err = load(dir, found, stack); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,248 @@
+package prf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type pref struct{ name, value string }
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name     string
+		profile  string
+		kinds    []Kind
+		prefs    []pref
+		includes []string
+	}{
+		{
+			name:    lineno(),
+			profile: "",
+		},
+		{
+			name: lineno(),
+			profile: `# Unison preferences
+label = Home directory
+root = /home/user
+root = ssh://backup.example.com//srv/backup/user
+
+path = Documents
+path = Pictures/2021
+ignore = Name *.tmp
+ignore = Path Documents/cache
+ignorenot = Name important.tmp
+`,
+			kinds: []Kind{Comment, Pref, Pref, Pref, Blank, Pref, Pref, Pref, Pref, Pref},
+			prefs: []pref{
+				{"label", "Home directory"},
+				{"root", "/home/user"},
+				{"root", "ssh://backup.example.com//srv/backup/user"},
+				{"path", "Documents"},
+				{"path", "Pictures/2021"},
+				{"ignore", "Name *.tmp"},
+				{"ignore", "Path Documents/cache"},
+				{"ignorenot", "Name important.tmp"},
+			},
+		},
+		{
+			name: lineno(),
+			profile: "root=/a\r\n" +
+				"  root   =   /b  \r\n" +
+				"\t# indented comment\r\n" +
+				"times = true",
+			kinds: []Kind{Pref, Pref, Comment, Pref},
+			prefs: []pref{{"root", "/a"}, {"root", "/b"}, {"times", "true"}},
+		},
+		{
+			name: lineno(),
+			profile: `include common
+include? local
+source /etc/unison/site.prf
+source? extra.prf
+includes = not a directive
+sourcefoo=bar
+auto =
+nonsense
+`,
+			kinds:    []Kind{Include, Include, Source, Source, Pref, Pref, Pref, Invalid},
+			prefs:    []pref{{"includes", "not a directive"}, {"sourcefoo", "bar"}, {"auto", ""}},
+			includes: []string{"common", "local?", "/etc/unison/site.prf", "extra.prf?"},
+		},
+		{
+			name:    lineno(),
+			profile: "ignore = Regex .*\\.(bak|orig)=old\n",
+			kinds:   []Kind{Pref},
+			prefs:   []pref{{"ignore", "Regex .*\\.(bak|orig)=old"}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f, err := Parse(strings.NewReader(c.profile))
+			require.NoError(t, err)
+			var kinds []Kind
+			var prefs []pref
+			var includes []string
+			for _, l := range f.Lines {
+				kinds = append(kinds, l.Kind)
+				switch l.Kind {
+				case Pref:
+					prefs = append(prefs, pref{l.Name, l.Value})
+				case Include, Source:
+					s := l.Value
+					if l.Optional {
+						s += "?"
+					}
+					includes = append(includes, s)
+				case Blank, Comment, Invalid:
+				}
+			}
+			assert.Equal(t, c.kinds, kinds)
+			assert.Equal(t, c.prefs, prefs)
+			assert.Equal(t, c.includes, includes)
+			assert.Equal(t, c.profile, string(f.Bytes()))
+		})
+	}
+}
+
+func TestEdit(t *testing.T) {
+	cases := []struct {
+		name     string
+		profile  string
+		edit     func(*File)
+		expected string
+	}{
+		{
+			name:     lineno(),
+			profile:  "# My profile\nroot  =  /a  \nroot = /b\n\ntimes=false\n",
+			edit:     func(f *File) { f.Set("times", "true") },
+			expected: "# My profile\nroot  =  /a  \nroot = /b\n\ntimes=true\n",
+		},
+		{
+			name:     lineno(),
+			profile:  "root  =  /a  \nroot = /b\n",
+			edit:     func(f *File) { f.Lines[0].SetValue("/home/user/a") },
+			expected: "root  =  /home/user/a  \nroot = /b\n",
+		},
+		{
+			name:     lineno(),
+			profile:  "root = /a\nroot = /b\n# ignores below\n",
+			edit:     func(f *File) { f.Add("ignore", "Name .git") },
+			expected: "root = /a\nroot = /b\n# ignores below\nignore = Name .git\n",
+		},
+		{
+			name:     lineno(),
+			profile:  "root = /a\r\nroot = /b",
+			edit:     func(f *File) { f.Set("perms", "0") },
+			expected: "root = /a\r\nroot = /b\r\nperms = 0\r\n",
+		},
+		{
+			name:     lineno(),
+			profile:  "",
+			edit:     func(f *File) { f.Add("root", "/a") },
+			expected: "root = /a\n",
+		},
+		{
+			name:    lineno(),
+			profile: "ignore = Name *.tmp\n# keep this\nignore = Name .git\nignore=Name *.tmp\n",
+			edit: func(f *File) {
+				assert.Equal(t, 2, f.Remove("ignore", "Name *.tmp"))
+				assert.Equal(t, 0, f.Remove("ignore", "Name *.bak"))
+			},
+			expected: "# keep this\nignore = Name .git\n",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f, err := Parse(strings.NewReader(c.profile))
+			require.NoError(t, err)
+			c.edit(f)
+			assert.Equal(t, c.expected, string(f.Bytes()))
+
+			// The edited file must parse the same as if it was written that way.
+			g, err := Parse(strings.NewReader(c.expected))
+			require.NoError(t, err)
+			require.Len(t, f.Lines, len(g.Lines))
+			for i := range g.Lines {
+				assert.Equal(t, g.Lines[i].Kind, f.Lines[i].Kind)
+				assert.Equal(t, g.Lines[i].Name, f.Lines[i].Name)
+				assert.Equal(t, g.Lines[i].Value, f.Lines[i].Value)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	site := filepath.Join(t.TempDir(), "site.prf")
+	files := map[string]string{
+		"work.prf": `# Work documents
+root = /home/user
+root = ssh://laptop//home/user
+include common
+include? local
+source ` + site + `
+path = Documents/work
+times = false
+`,
+		"common.prf": "ignore = Name .git\nignore = Name *.tmp\nignorenot = Name keep.tmp\ntimes = true\n",
+		site:         "ignore = Path Documents/work/archive\n",
+		"loop.prf":   "root = /a\ninclude loop2\n",
+		"loop2":      "include loop\n",
+		"broken.prf": "include nonexistent\n",
+	}
+	for name, content := range files {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		require.NoError(t, os.WriteFile(name, []byte(content), 0o600))
+	}
+
+	f, err := Load(dir, "work")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "work.prf"), f.Name)
+	assert.Equal(t, []string{"/home/user", "ssh://laptop//home/user"}, f.Roots())
+	assert.Equal(t, []string{"Documents/work"}, f.Paths())
+	assert.Equal(t, []string{"Name .git", "Name *.tmp", "Path Documents/work/archive"}, f.Ignores())
+	assert.Equal(t, []string{"Name keep.tmp"}, f.IgnoreNots())
+	assert.Equal(t, []string{"true", "false"}, f.Values("times"))
+	value, ok := f.Value("times")
+	assert.True(t, ok)
+	assert.Equal(t, "false", value)
+	_, ok = f.Value("perms")
+	assert.False(t, ok)
+
+	f.Set("times", "true")
+	f.Lines[5].Included.Add("ignore", "Name *.bak")
+	require.NoError(t, f.Save())
+	require.NoError(t, f.Lines[5].Included.Save())
+	f, err = Load(dir, "work")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Name .git", "Name *.tmp", "Path Documents/work/archive", "Name *.bak"},
+		f.Ignores())
+	value, _ = f.Value("times")
+	assert.Equal(t, "true", value)
+
+	_, err = Load(dir, "loop")
+	assert.Error(t, err)
+	_, err = Load(dir, "broken")
+	assert.Error(t, err)
+	_, err = Load(dir, "nonexistent")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func lineno() string {
+	_, _, line, ok := runtime.Caller(1)
+	if !ok {
+		panic("lineno: failed to find Caller")
+	}
+	return fmt.Sprintf("line%d", line)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vfaronov/gunison/prf"
+)
+
+// A Profile is a Unison profile (a .prf file) that the user may choose to run.
+type Profile struct {
+	Name    string    // as passed to Unison, i.e. the file name without .prf
+	Roots   []string  // as set in the file and the files it includes
+	ModTime time.Time // of the file
+}
+
+// ProfileDir returns the directory where Unison looks for profiles: $UNISON or ~/.unison.
+func ProfileDir() (string, error) {
+	if dir := os.Getenv("UNISON"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".unison"), nil
+}
+
+// ProfileArg returns the name of the profile that Unison will use when run with args: the first
+// argument that names an existing profile in dir, or "default" if there's no such argument.
+func ProfileArg(args []string, dir string) string {
+	for _, arg := range args {
+		if arg == "" || strings.HasPrefix(arg, "-") || strings.ContainsAny(arg, `/\`) {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(dir, arg+".prf")); err == nil && !info.IsDir() {
+			return arg
+		}
+	}
+	return "default"
+}
+
+// ListProfiles returns the profiles found in dir, sorted by name. A profile that can't be read
+// is still listed, but without Roots.
+func ListProfiles(dir string) ([]Profile, error) {
+	filenames, err := filepath.Glob(filepath.Join(dir, "*.prf"))
+	if err != nil {
+		return nil, err
+	}
+	profiles := make([]Profile, 0, len(filenames))
+	for _, filename := range filenames {
+		info, err := os.Stat(filename)
+		if errors.Is(err, os.ErrNotExist) { // dangling symlink?
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			continue
+		}
+		var roots []string
+		if f, err := prf.LoadFile(dir, filename); err == nil {
+			roots = f.Roots()
+		}
+		profiles = append(profiles, Profile{
+			Name:    strings.TrimSuffix(filepath.Base(filename), ".prf"),
+			Roots:   roots,
+			ModTime: info.ModTime(),
+		})
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListProfiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"default.prf": "# Unison preferences file\n",
+		"work.prf": `# Sync work documents with the laptop
+root = /home/user
+root=ssh://laptop//home/user
+  rootalias = //old//home/user -> //new//home/user
+path = Documents/work
+# root = /mnt/backup
+include common
+`,
+		"common":          "ignore = Name .git\nroot = /mnt/common\n",
+		"ar1234567890abc": "binary archive",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	modTime := time.Date(2021, 3, 14, 15, 9, 26, 0, time.Local)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "work.prf"), modTime, modTime))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "backup.prf"), 0o700))
+
+	profiles, err := ListProfiles(dir)
+	require.NoError(t, err)
+	require.Len(t, profiles, 2)
+	assertEqual(t, profiles[0].Name, "default")
+	assert.Nil(t, profiles[0].Roots)
+	assertEqual(t, profiles[1].Name, "work")
+	assertEqual(t, profiles[1].Roots, []string{"/home/user", "ssh://laptop//home/user", "/mnt/common"})
+	assert.True(t, profiles[1].ModTime.Equal(modTime))
+}
+
+func TestProfileDir(t *testing.T) {
+	t.Setenv("UNISON", "/tmp/unison")
+	dir, err := ProfileDir()
+	require.NoError(t, err)
+	assertEqual(t, dir, "/tmp/unison")
+
+	t.Setenv("UNISON", "")
+	dir, err = ProfileDir()
+	require.NoError(t, err)
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+	assertEqual(t, dir, filepath.Join(home, ".unison"))
+}
+
+func TestProfileArg(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "work.prf"), nil, 0o600))
+	assertEqual(t, ProfileArg(nil, dir), "default")
+	assertEqual(t, ProfileArg([]string{"-batch", "work"}, dir), "work")
+	assertEqual(t, ProfileArg([]string{"/home/user", "ssh://laptop//home/user"}, dir), "default")
+	assertEqual(t, ProfileArg([]string{"-path", "Documents", "work", "/a", "/b"}, dir), "work")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/fs"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Properties of a replica's content, as far as Unison describes them (see ParseProperties).
+// The zero Properties mean that nothing is known.
+type Properties struct {
+	ModTime  time.Time   // zero if unknown
+	Size     int64       // in bytes
+	HasSize  bool        // false if Size is unknown
+	Perms    fs.FileMode // permission bits, including setuid, setgid, and sticky
+	HasPerms bool        // false if Perms are unknown, e.g. because Unison doesn't synchronize them
+	Extra    string      // anything else Unison says, e.g. about extended attributes or ACLs
+}
+
+var (
+	patModTime = regexp.MustCompile(`^modified on (\d{4})-(\d\d)-(\d\d) at +(\d?\d):(\d\d):(\d\d)\s*`)
+	patSize    = regexp.MustCompile(`^size (\d+)\s*`)
+	patPerms   = regexp.MustCompile(`^(?:unknown permissions|([r-][w-][xsS-][r-][w-][xsS-][r-][w-][xtT-]))(?:\s+|$)`)
+)
+
+// ParseProperties parses Unison's description of properties, such as
+// "modified on 2021-02-07 at  1:50:31  size 1146      rw-r--r--". Unison prints times
+// in the local time zone. Whatever it cannot make sense of ends up in Extra.
+func ParseProperties(s string) Properties {
+	var p Properties
+	rest := strings.TrimSpace(s)
+	if m := patModTime.FindStringSubmatch(rest); m != nil {
+		var n [6]int
+		for i := range n {
+			n[i], _ = strconv.Atoi(m[i+1]) // can't fail due to the regexp
+		}
+		p.ModTime = time.Date(n[0], time.Month(n[1]), n[2], n[3], n[4], n[5], 0, time.Local)
+		rest = rest[len(m[0]):]
+	}
+	if m := patSize.FindStringSubmatch(rest); m != nil {
+		if size, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+			p.Size, p.HasSize = size, true
+			rest = rest[len(m[0]):]
+		}
+	}
+	if m := patPerms.FindStringSubmatch(rest); m != nil {
+		if m[1] != "" {
+			p.Perms, p.HasPerms = parsePerms(m[1]), true
+		}
+		rest = rest[len(m[0]):]
+	}
+	p.Extra = rest
+	return p
+}
+
+// parsePerms parses permissions in the format of ls -l, such as "rwxr-sr-t".
+func parsePerms(s string) fs.FileMode {
+	var perms fs.FileMode
+	for i, c := range s {
+		if c != '-' && c != 'S' && c != 'T' { // capital letters mean the x bit is not set
+			perms |= 0o400 >> i
+		}
+		switch {
+		case i == 2 && (c == 's' || c == 'S'):
+			perms |= fs.ModeSetuid
+		case i == 5 && (c == 's' || c == 'S'):
+			perms |= fs.ModeSetgid
+		case i == 8 && (c == 't' || c == 'T'):
+			perms |= fs.ModeSticky
+		}
+	}
+	return perms
+}
+
+// formatPerms is the inverse of parsePerms.
+func formatPerms(perms fs.FileMode) string {
+	b := []byte("rwxrwxrwx")
+	for i := range b {
+		if perms&(0o400>>i) == 0 {
+			b[i] = '-'
+		}
+	}
+	special := func(i int, bit fs.FileMode, lower, upper byte) {
+		switch {
+		case perms&bit == 0:
+		case b[i] == 'x':
+			b[i] = lower
+		default:
+			b[i] = upper
+		}
+	}
+	special(2, fs.ModeSetuid, 's', 'S')
+	special(5, fs.ModeSetgid, 's', 'S')
+	special(8, fs.ModeSticky, 't', 'T')
+	return string(b)
+}
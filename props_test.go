@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"pgregory.net/rapid"
+)
+
+func TestParseProperties(t *testing.T) {
+	cases := []struct {
+		name     string
+		props    string
+		expected Properties
+	}{
+		{
+			name:  lineno(),
+			props: "modified on 2021-02-06 at 18:41:58  size 1146      rw-r--r--",
+			expected: Properties{
+				ModTime:  time.Date(2021, 2, 6, 18, 41, 58, 0, time.Local),
+				Size:     1146,
+				HasSize:  true,
+				Perms:    0o644,
+				HasPerms: true,
+			},
+		},
+		{
+			name:  lineno(),
+			props: "modified on 2021-02-07 at  1:50:31  size 10000000  rwxr-xr-x",
+			expected: Properties{
+				ModTime:  time.Date(2021, 2, 7, 1, 50, 31, 0, time.Local),
+				Size:     10000000,
+				HasSize:  true,
+				Perms:    0o755,
+				HasPerms: true,
+			},
+		},
+		{
+			name:  lineno(),
+			props: "modified on 1970-01-01 at  3:00:00  size 0         unknown permissions",
+			expected: Properties{
+				ModTime: time.Date(1970, 1, 1, 3, 0, 0, 0, time.Local),
+				HasSize: true,
+			},
+		},
+		{
+			name:  lineno(),
+			props: "modified on 2021-02-06 at 18:41:58  size 2292      rwxr-sr-t",
+			expected: Properties{
+				ModTime:  time.Date(2021, 2, 6, 18, 41, 58, 0, time.Local),
+				Size:     2292,
+				HasSize:  true,
+				Perms:    0o755 | fs.ModeSetgid | fs.ModeSticky,
+				HasPerms: true,
+			},
+		},
+		{
+			name:  lineno(),
+			props: "modified on 2021-02-06 at 18:41:58  size 0         rwSr--r-T",
+			expected: Properties{
+				ModTime:  time.Date(2021, 2, 6, 18, 41, 58, 0, time.Local),
+				HasSize:  true,
+				Perms:    0o644 | fs.ModeSetuid | fs.ModeSticky,
+				HasPerms: true,
+			},
+		},
+		{
+			name:  lineno(),
+			props: "modified on 2023-05-10 at 14:02:11  size 1146      r--r--r--  xattrs 2  ACL",
+			expected: Properties{
+				ModTime:  time.Date(2023, 5, 10, 14, 2, 11, 0, time.Local),
+				Size:     1146,
+				HasSize:  true,
+				Perms:    0o444,
+				HasPerms: true,
+				Extra:    "xattrs 2  ACL",
+			},
+		},
+		{
+			name:  lineno(),
+			props: "modified on 2021-02-06 at 18:41:58  size 9         ",
+			expected: Properties{
+				ModTime: time.Date(2021, 2, 6, 18, 41, 58, 0, time.Local),
+				Size:    9,
+				HasSize: true,
+			},
+		},
+		{
+			name:  lineno(),
+			props: "size 1146      rw-r--r--",
+			expected: Properties{
+				Size:     1146,
+				HasSize:  true,
+				Perms:    0o644,
+				HasPerms: true,
+			},
+		},
+		{
+			name:  lineno(),
+			props: "modified on 2021-02-06 at 18:41:58  size 99999999999999999999  rw-r--r--",
+			expected: Properties{
+				ModTime: time.Date(2021, 2, 6, 18, 41, 58, 0, time.Local),
+				Extra:   "size 99999999999999999999  rw-r--r--",
+			},
+		},
+		{
+			name:     lineno(),
+			props:    "something Gunison doesn't understand",
+			expected: Properties{Extra: "something Gunison doesn't understand"},
+		},
+		{
+			name:     lineno(),
+			props:    "",
+			expected: Properties{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assertEqual(t, ParseProperties(c.props), c.expected)
+		})
+	}
+}
+
+// TestFormatPerms checks that formatPerms is the inverse of parsePerms.
+func TestFormatPerms(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		perms := fs.FileMode(rapid.Uint32Range(0, 0o777).Draw(t, "perms").(uint32))
+		for _, bit := range []fs.FileMode{fs.ModeSetuid, fs.ModeSetgid, fs.ModeSticky} {
+			if rapid.Bool().Draw(t, "special").(bool) {
+				perms |= bit
+			}
+		}
+		s := formatPerms(perms)
+		assert.Len(t, s, 9)
+		assert.Equal(t, perms, parsePerms(s), s)
+	})
+}
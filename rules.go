@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// A Rule sets the action for the Items it matches automatically, as soon as Unison has assembled
+// the plan, as if the user had set it. Rules are parsed from text by ParseRules.
+type Rule struct {
+	Text        string // the line that defines the rule, for showing to the user
+	Action      Action
+	pattern     *regexp.Regexp // matched against the entire Path
+	byName      bool           // if true, pattern is matched against the last segment of Path instead
+	left, right []string       // the corresponding Content must have one of these statuses or types (if any)
+}
+
+// ParseRules parses text with one rule per line, such as:
+//
+//	skip *.lock
+//	left-to-right left=modified right=unchanged|modified .config/**
+//	right-to-left regex:Photos/[0-9]{4}/.*
+//
+// Each line has an action name (one of decisionActions), optional conditions on the left and right
+// Content (names of statuses or types from statusNames and typeNames, separated by |),
+// and a pattern that is the rest of the line. The pattern is a regular expression if it begins
+// with "regex:", otherwise a glob, where ** also matches slashes. Either must match the entire Path,
+// except that a glob without slashes is matched against the last segment of Path, like a name.
+// Blank lines and lines beginning with # are ignored.
+func ParseRules(text string) ([]Rule, error) {
+	var rules []Rule
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %w", lineno, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+func parseRule(line string) (Rule, error) {
+	rule := Rule{Text: line}
+	fields := strings.SplitN(line, " ", 2)
+	if len(fields) < 2 {
+		return rule, errors.New("expected an action and a pattern.")
+	}
+	act, ok := decisionActions[fields[0]]
+	if !ok {
+		return rule, fmt.Errorf("unknown action %q.", fields[0])
+	}
+	rule.Action = act
+
+	rest := strings.TrimLeft(fields[1], " ")
+	for {
+		var side *[]string
+		switch {
+		case strings.HasPrefix(rest, "left="):
+			side = &rule.left
+		case strings.HasPrefix(rest, "right="):
+			side = &rule.right
+		}
+		if side == nil {
+			break
+		}
+		fields = strings.SplitN(rest, " ", 2)
+		if len(fields) < 2 {
+			return rule, fmt.Errorf("expected a pattern after %s.", fields[0])
+		}
+		for _, name := range strings.Split(strings.SplitN(fields[0], "=", 2)[1], "|") {
+			if !isContentName(name) {
+				return rule, fmt.Errorf("unknown status or type %q.", name)
+			}
+			*side = append(*side, name)
+		}
+		rest = strings.TrimLeft(fields[1], " ")
+	}
+
+	var expr string
+	if strings.HasPrefix(rest, "regex:") {
+		expr = "^(?:" + strings.TrimPrefix(rest, "regex:") + ")$"
+	} else {
+		expr = "^" + globToRegexp(rest) + "$"
+		rule.byName = !strings.Contains(rest, "/")
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return rule, fmt.Errorf("invalid pattern: %w", err)
+	}
+	rule.pattern = pattern
+	return rule, nil
+}
+
+func isContentName(name string) bool {
+	for _, s := range statusNames {
+		if name == s {
+			return true
+		}
+	}
+	for _, s := range typeNames {
+		if name == s {
+			return true
+		}
+	}
+	return false
+}
+
+// globToRegexp converts a glob (as described at ParseRules) to an unanchored regular expression.
+// A trailing /** also matches the directory itself.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	suffix := ""
+	if strings.HasSuffix(glob, "/**") {
+		glob = strings.TrimSuffix(glob, "/**")
+		suffix = "(?:/.*)?"
+	}
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[' && strings.IndexByte(glob[i+1:], ']') > 0:
+			end := i + 1 + strings.IndexByte(glob[i+1:], ']')
+			class := glob[i+1 : end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i = end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString(suffix)
+	return b.String()
+}
+
+// Match reports whether r applies to item.
+func (r *Rule) Match(item Item) bool {
+	target := item.Path
+	if r.byName {
+		target = target[strings.LastIndexByte(target, '/')+1:]
+	}
+	return r.pattern.MatchString(target) && matchContentNames(item.Left, r.left) &&
+		matchContentNames(item.Right, r.right)
+}
+
+func matchContentNames(c Content, names []string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, name := range names {
+		if name == statusNames[c.Status] || name == typeNames[c.Type] {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyRules sets the Override of each of items to the Action of the first of rules that applies
+// to it, if any, and records that Rule in the item. A rule does not apply to an item
+// whose Override cannot be set to its Action (see Item.CanSet).
+func ApplyRules(items []Item, rules []Rule) {
+	for i := range items {
+		item := &items[i]
+		for j := range rules {
+			rule := &rules[j]
+			if item.CanSet(rule.Action) && rule.Match(*item) {
+				item.Override = rule.Action
+				item.Rule = rule
+				break
+			}
+		}
+	}
+}
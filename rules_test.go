@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRulesErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		err  string
+	}{
+		{lineno(), "skip", "Line 1: expected an action and a pattern."},
+		{lineno(), "# comment\n\nignore *.lock", `Line 3: unknown action "ignore".`},
+		{lineno(), "problem *.lock", `Line 1: unknown action "problem".`},
+		{lineno(), "skip left=modified", "Line 1: expected a pattern after left=modified."},
+		{lineno(), "skip right=file|changed *.lock", `Line 1: unknown status or type "changed".`},
+		{lineno(), "skip regex:foo(", "Line 1: invalid pattern: error parsing regexp: missing closing ): `^(?:foo()$`"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := ParseRules(c.text)
+			assert.EqualError(t, err, c.err)
+		})
+	}
+}
+
+func TestRuleMatch(t *testing.T) {
+	cases := []struct {
+		name     string
+		rule     string
+		item     Item
+		expected bool
+	}{
+		{lineno(), "skip *.lock", item("Cargo.lock"), true},
+		{lineno(), "skip *.lock", item("src/Cargo.lock"), true},
+		{lineno(), "skip *.lock", item("src/Cargo.lock.bak"), false},
+		{lineno(), "skip *.lock", item(""), false},
+		{lineno(), "skip src/*.lock", item("src/Cargo.lock"), true},
+		{lineno(), "skip src/*.lock", item("Cargo.lock"), false},
+		{lineno(), "skip src/*.lock", item("src/a/Cargo.lock"), false},
+		{lineno(), "skip src/**.lock", item("src/a/Cargo.lock"), true},
+		{lineno(), "right-to-left Photos/**", item("Photos"), true},
+		{lineno(), "right-to-left Photos/**", item("Photos/2021/a.jpg"), true},
+		{lineno(), "right-to-left Photos/**", item("Photos2/a.jpg"), false},
+		{lineno(), "right-to-left Photos/**", item("My/Photos/a.jpg"), false},
+		{lineno(), "skip file?.txt", item("file1.txt"), true},
+		{lineno(), "skip file?.txt", item("file10.txt"), false},
+		{lineno(), "skip file[0-9].txt", item("file1.txt"), true},
+		{lineno(), "skip file[!0-9].txt", item("file1.txt"), false},
+		{lineno(), "skip file[!0-9].txt", item("fileA.txt"), true},
+		{lineno(), "skip a+b (1).txt", item("a+b (1).txt"), true},
+		{lineno(), "skip a+b (1).txt", item("aab (1).txt"), false},
+		{lineno(), "skip regex:Photos/[0-9]{4}/.*", item("Photos/2021/a.jpg"), true},
+		{lineno(), "skip regex:Photos/[0-9]{4}/.*", item("Old/Photos/2021/a.jpg"), false},
+		{lineno(), "skip regex:.*\\.(jpg|png)", item("a/b.png"), true},
+		{lineno(), "left-to-right left=modified .config/**", item(".config/foo", Modified), true},
+		{lineno(), "left-to-right left=modified .config/**", item(".config/foo", Created), false},
+		{lineno(), "left-to-right left=modified|created .config/**", item(".config/foo", Created), true},
+		{lineno(), "left-to-right left=directory right=absent|deleted *",
+			item("foo", Directory, Created, LeftToRight, Absent), true},
+		{lineno(), "left-to-right left=directory right=absent|deleted *",
+			item("foo", Directory, Created, LeftToRight, File, Unchanged), false},
+		{lineno(), "left-to-right   right=unchanged   *.txt", item("foo.txt"), true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rules, err := ParseRules(c.rule)
+			require.NoError(t, err)
+			require.Len(t, rules, 1)
+			assertEqual(t, rules[0].Match(c.item), c.expected)
+		})
+	}
+}
+
+func TestApplyRules(t *testing.T) {
+	rules, err := ParseRules(`
+		# First matching rule wins.
+		merge *.txt
+		skip *.lock
+		right-to-left docs/**
+	`)
+	require.NoError(t, err)
+	require.Len(t, rules, 3)
+	items := []Item{
+		item("a.txt"),
+		item("docs/Cargo.lock", Modified, Skip, Modified),
+		item("docs/b.txt", Modified, Skip, Absent, ""),
+		item("docs/c.md", Problem),
+		item("d.md"),
+	}
+	ApplyRules(items, rules)
+	assertEqual(t, items[0].Override, Merge)
+	assert.Same(t, &rules[0], items[0].Rule)
+	assertEqual(t, items[1].Override, Skip)
+	assert.Same(t, &rules[1], items[1].Rule)
+	assertEqual(t, items[2].Override, RightToLeft) // can't merge with an absent file
+	assert.Same(t, &rules[2], items[2].Rule)
+	assertEqual(t, items[3].Override, NoAction)
+	assert.Nil(t, items[3].Rule)
+	assertEqual(t, items[4].Override, NoAction)
+	assert.Nil(t, items[4].Rule)
+
+	assert.True(t, items[0].IsAutomatic())
+	items[0].Override = LeftToRight
+	assert.False(t, items[0].IsAutomatic())
+	items[0].Override = Merge // e.g. undo
+	assert.True(t, items[0].IsAutomatic())
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A Session is a synchronization between two roots without a profile, as set up by the user.
+type Session struct {
+	Roots  [2]string
+	Prefer string // "", "left" or "right" (meaning the respective root), "newer" or "older"
+	Times  bool   // whether to synchronize modification times
+	Perms  bool   // whether to synchronize permissions
+}
+
+// Validate returns an error if the session can't be passed on to Unison.
+func (s Session) Validate() error {
+	for _, root := range s.Roots {
+		if err := ValidateRoot(root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefs returns the preferences (other than roots) that Unison needs to be given for s,
+// as pairs of name and value.
+func (s Session) prefs() [][2]string {
+	var prefs [][2]string
+	switch s.Prefer {
+	case "":
+	case "left":
+		prefs = append(prefs, [2]string{"prefer", s.Roots[0]})
+	case "right":
+		prefs = append(prefs, [2]string{"prefer", s.Roots[1]})
+	default:
+		prefs = append(prefs, [2]string{"prefer", s.Prefer})
+	}
+	if s.Times {
+		prefs = append(prefs, [2]string{"times", "true"})
+	}
+	if !s.Perms {
+		prefs = append(prefs, [2]string{"perms", "0"})
+	}
+	return prefs
+}
+
+// Args returns command-line arguments for running Unison on s.
+func (s Session) Args() []string {
+	args := []string{s.Roots[0], s.Roots[1]}
+	for _, pref := range s.prefs() {
+		args = append(args, "-"+pref[0]+"="+pref[1])
+	}
+	return args
+}
+
+// Profile returns the contents of a Unison profile (.prf file) equivalent to s.
+func (s Session) Profile() string {
+	var b strings.Builder
+	b.WriteString("# Created by Gunison\n")
+	for _, root := range s.Roots {
+		fmt.Fprintf(&b, "root = %s\n", root)
+	}
+	for _, pref := range s.prefs() {
+		fmt.Fprintf(&b, "%s = %s\n", pref[0], pref[1])
+	}
+	return b.String()
+}
+
+// SaveProfile writes s as a new profile with the given name into dir, which is created if needed.
+// It refuses to overwrite an existing profile.
+func (s Session) SaveProfile(dir, name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("Invalid profile name: %q", name)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	filename := filepath.Join(dir, name+".prf")
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("Profile %s already exists.", name)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(s.Profile()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ValidateRoot returns an error if root is obviously not something that Unison accepts as a root.
+// A root is either a local path, or a URI such as ssh://[user@]host[:port]//absolute/path,
+// ssh://host/path/relative/to/home, or socket://host:port/path.
+func ValidateRoot(root string) error {
+	if strings.TrimSpace(root) == "" {
+		return errors.New("Root must not be empty.")
+	}
+	i := strings.Index(root, "://")
+	if i == -1 { // local path
+		return nil
+	}
+	scheme, rest := root[:i], root[i+len("://"):]
+	switch scheme {
+	case "file":
+		return nil
+	case "ssh", "rsh", "socket":
+	default:
+		return fmt.Errorf("Unknown protocol in root %s: expected ssh://, socket:// or a local path.", root)
+	}
+
+	if scheme == "socket" && strings.HasPrefix(rest, "{") { // Unix domain socket: socket://{/path}/root
+		if !strings.Contains(rest, "}") {
+			return fmt.Errorf("Missing } in root %s.", root)
+		}
+		return nil
+	}
+	hostPort := rest
+	if j := strings.IndexByte(rest, '/'); j != -1 {
+		hostPort = rest[:j]
+	}
+	if at := strings.LastIndexByte(hostPort, '@'); at != -1 {
+		if at == 0 {
+			return fmt.Errorf("Empty user name in root %s.", root)
+		}
+		hostPort = hostPort[at+1:]
+	}
+	host, port := hostPort, ""
+	if c := strings.LastIndexByte(hostPort, ':'); c != -1 {
+		host, port = hostPort[:c], hostPort[c+1:]
+		if port == "" || strings.Trim(port, "0123456789") != "" {
+			return fmt.Errorf("Invalid port in root %s.", root)
+		}
+	}
+	if host == "" {
+		return fmt.Errorf("No host in root %s.", root)
+	}
+	if scheme == "socket" && port == "" {
+		return fmt.Errorf("No port in root %s: socket:// roots need one.", root)
+	}
+	return nil
+}
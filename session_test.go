@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRoot(t *testing.T) {
+	cases := []struct {
+		name string
+		root string
+		ok   bool
+	}{
+		{name: lineno(), root: "/home/user", ok: true},
+		{name: lineno(), root: "relative/dir", ok: true},
+		{name: lineno(), root: `C:\Users\user`, ok: true},
+		{name: lineno(), root: "file:///home/user", ok: true},
+		{name: lineno(), root: "ssh://host//home/user", ok: true},
+		{name: lineno(), root: "ssh://user@host/Documents", ok: true},
+		{name: lineno(), root: "ssh://user@host:2222//home/user", ok: true},
+		{name: lineno(), root: "ssh://host", ok: true},
+		{name: lineno(), root: "socket://host:1234//home/user", ok: true},
+		{name: lineno(), root: "socket://{/run/unison.sock}//home/user", ok: true},
+		{name: lineno(), root: "", ok: false},
+		{name: lineno(), root: "  ", ok: false},
+		{name: lineno(), root: "http://host/path", ok: false},
+		{name: lineno(), root: "ssh:///home/user", ok: false},
+		{name: lineno(), root: "ssh://@host//home/user", ok: false},
+		{name: lineno(), root: "ssh://host:port//home/user", ok: false},
+		{name: lineno(), root: "ssh://host://home/user", ok: false},
+		{name: lineno(), root: "socket://host//home/user", ok: false},
+		{name: lineno(), root: "socket://{/run/unison.sock//home/user", ok: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := ValidateRoot(c.root)
+			if c.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestSession(t *testing.T) {
+	s := Session{
+		Roots:  [2]string{"/home/user", "ssh://laptop//home/user"},
+		Prefer: "right",
+		Times:  true,
+	}
+	assert.NoError(t, s.Validate())
+	assertEqual(t, s.Args(), []string{
+		"/home/user", "ssh://laptop//home/user",
+		"-prefer=ssh://laptop//home/user", "-times=true", "-perms=0",
+	})
+	assertEqual(t, s.Profile(), "# Created by Gunison\n"+
+		"root = /home/user\n"+
+		"root = ssh://laptop//home/user\n"+
+		"prefer = ssh://laptop//home/user\n"+
+		"times = true\n"+
+		"perms = 0\n")
+
+	s = Session{Roots: [2]string{"a", "b"}, Prefer: "newer", Perms: true}
+	assertEqual(t, s.Args(), []string{"a", "b", "-prefer=newer"})
+
+	s.Roots[1] = "ftp://host/b"
+	assert.Error(t, s.Validate())
+}
+
+func TestSessionSaveProfile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), ".unison")
+	s := Session{Roots: [2]string{"a", "b"}, Perms: true}
+	require.NoError(t, s.SaveProfile(dir, "ab"))
+	content, err := os.ReadFile(filepath.Join(dir, "ab.prf"))
+	require.NoError(t, err)
+	assertEqual(t, string(content), s.Profile())
+
+	assert.Error(t, s.SaveProfile(dir, "ab"))
+	assert.Error(t, s.SaveProfile(dir, ""))
+	assert.Error(t, s.SaveProfile(dir, "../ab"))
+}
@@ -0,0 +1,60 @@
+package main
+
+// A Summary tells what synchronizing a plan will do, in numbers.
+type Summary struct {
+	LeftToRight, RightToLeft int // items to propagate, including partially
+	Merge                    int
+	Skip                     int // items the user has chosen to skip
+	Conflicts                int // items Unison doesn't know what to do with, and the user hasn't decided
+	Problems                 int
+	DeleteLeft, DeleteRight  int // items to delete in the left and right replicas
+	// Estimated from the sizes Unison reports, not counting merges and deletions.
+	BytesLeftToRight, BytesRightToLeft int64
+}
+
+// Summarize computes the Summary of items according to their current actions.
+func Summarize(items []Item) Summary {
+	var s Summary
+	for _, item := range items {
+		//nolint:exhaustive
+		switch item.Action() {
+		case LeftToRight, LeftToRightPartial:
+			s.LeftToRight++
+			s.BytesLeftToRight += transferSize(item.Left)
+		case RightToLeft, RightToLeftPartial:
+			s.RightToLeft++
+			s.BytesRightToLeft += transferSize(item.Right)
+		case Merge:
+			s.Merge++
+		case Skip:
+			if isConflict(item) {
+				s.Conflicts++
+			} else {
+				s.Skip++
+			}
+		case Problem:
+			s.Problems++
+		}
+		left, right := deletions(item)
+		if left {
+			s.DeleteLeft++
+		}
+		if right {
+			s.DeleteRight++
+		}
+	}
+	return s
+}
+
+// transferSize returns how many bytes must be copied to propagate the source content.
+func transferSize(source Content) int64 {
+	if source.Status != Created && source.Status != Modified {
+		return 0 // just props or a deletion
+	}
+	return source.Size
+}
+
+// isConflict reports whether Unison doesn't know what to do with item, and the user hasn't decided either.
+func isConflict(item Item) bool {
+	return item.Action() == Skip && !item.IsOverridden()
+}
@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+func TestSummarize(t *testing.T) {
+	cases := []struct {
+		name     string
+		items    []Item
+		expected Summary
+	}{
+		{
+			name:     lineno(),
+			items:    []Item{},
+			expected: Summary{},
+		},
+		{
+			name: lineno(),
+			items: []Item{
+				item("foo", Created, "size 1000  rw-r--r--", LeftToRight, Absent, ""),
+				item("bar", Modified, "size 20  rw-r--r--", LeftToRight, Unchanged, "size 10  rw-r--r--"),
+				item("baz", PropsChanged, "size 300  rw-r--r--", LeftToRight, Unchanged),
+				item("qux", Unchanged, "size 4  rw-r--r--", RightToLeft, Modified, "size 5  rw-r--r--"),
+			},
+			expected: Summary{
+				LeftToRight:      3,
+				RightToLeft:      1,
+				BytesLeftToRight: 1020,
+				BytesRightToLeft: 5,
+			},
+		},
+		{
+			name: lineno(),
+			items: []Item{
+				item("foo", Absent, Deleted, "", LeftToRight, Unchanged),
+				item("bar", Absent, Deleted, "", LeftToRight, Unchanged),
+				item("baz", Unchanged, RightToLeft, Absent, Deleted, ""),
+				item("qux", Absent, Deleted, "", RightToLeft, Modified, "size 5  rw-r--r--"),
+			},
+			expected: Summary{
+				LeftToRight:      2,
+				RightToLeft:      2,
+				DeleteLeft:       1,
+				DeleteRight:      2,
+				BytesRightToLeft: 5,
+			},
+		},
+		{
+			name: lineno(),
+			items: []Item{
+				item("foo", Modified, Skip, Modified),
+				item("bar", Modified, Skip, Modified, Skip),
+				item("baz", Modified, Skip, Modified, Merge),
+				item("qux", Modified, LeftToRight, Modified, Skip),
+				item("xyzzy", Problem),
+				item("plugh", Modified, Merge, Modified),
+			},
+			expected: Summary{
+				Merge:     2,
+				Skip:      2,
+				Conflicts: 1,
+				Problems:  1,
+			},
+		},
+		{
+			name: lineno(),
+			items: []Item{
+				item("foo", Deleted, Absent, Skip, Modified, RightToLeft),
+				item("bar", Deleted, Absent, Skip, Modified, LeftToRight),
+			},
+			expected: Summary{
+				LeftToRight: 1,
+				RightToLeft: 1,
+				DeleteRight: 1,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assertEqual(t, Summarize(c.items), c.expected)
+		})
+	}
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"html"
 	"log"
 	"mime"
+	"os"
 	"path"
+	"path/filepath"
 	"sort"
 	"strings"
 
@@ -27,26 +30,42 @@ const (
 	colNameColor
 	colActionColor
 	colPath
+	colResult
+	colResultColor
+	colLeftSize
+	colLeftModTime
+	colRightSize
+	colRightModTime
+	colPerms
+	colType
 )
 
 const invalid = -1
 
-// displayItems makes the treeview display core.Items, one leaf node per Item,
-// possibly arranging them in a tree and generating parent nodes as appropriate.
-// It satisfies several properties defined in tree_test.go.
+// displayItems makes the treeview display those of core.Items that pass the filter,
+// one leaf node per Item, possibly arranging them in a tree and generating parent nodes
+// as appropriate. It satisfies several properties defined in tree_test.go.
 func displayItems() {
+	shown := make([]int, 0, len(core.Items)) // indices into core.Items
+	for i := range core.Items {
+		if filter.Match(core.Items[i]) {
+			shown = append(shown, i)
+		}
+	}
+
 	// First, we do a pass over all items to find path prefixes covering contiguous runs of items.
 	// A prefix covering multiple items may be extracted into a parent node.
 	// Also determine combined actions to be displayed on these parent nodes.
 	type cover struct {
-		root       int
+		root       int // this and the following are indices into shown
 		start, end int
 		action     Action
 		overridden bool
 		sealed     bool
 	}
-	covers := make(map[string]cover, 2*len(core.Items)) // at least one entry per item, plus some parents
-	for i, item := range core.Items {
+	covers := make(map[string]cover, 2*len(shown)) // at least one entry per item, plus some parents
+	for i, idx := range shown {
+		item := core.Items[idx]
 		path := item.Path
 		for prefix, k := Prefix(path, 0); k != -1; prefix, k = Prefix(path, k) {
 			cover, seen := covers[prefix]
@@ -90,7 +109,7 @@ func displayItems() {
 			default:
 				cover.start, cover.end = cover.root, cover.root
 				if cover.root != invalid {
-					item := core.Items[cover.root]
+					item := core.Items[shown[cover.root]]
 					cover.action, cover.overridden = item.Action(), item.IsOverridden()
 				}
 			}
@@ -98,10 +117,21 @@ func displayItems() {
 		}
 	}
 
+	// Folder nodes show the total size of the items they contain, which is quick to compute
+	// for any cover from running totals over all items.
+	leftTotals := make([]sizeTotal, len(shown)+1)
+	rightTotals := make([]sizeTotal, len(shown)+1)
+	for i, idx := range shown {
+		leftTotals[i+1] = leftTotals[i].add(core.Items[idx].Left.Properties)
+		rightTotals[i+1] = rightTotals[i].add(core.Items[idx].Right.Properties)
+	}
+
 	// On my system, this makes the following code 30% faster on a large plan.
 	reattachModel := DetachModel(treeview)
 
 	treestore.Clear()
+	itemIters = make([]*gtk.TreeIter, len(core.Items))
+	displayedResults = make([]Result, len(core.Items))
 
 	// As we generate tree nodes, we will be keeping a stack of parent nodes.
 	// TODO: This should probably be refactored for ease of understanding, but
@@ -111,7 +141,7 @@ func displayItems() {
 		iter   *gtk.TreeIter
 		end    int
 	}
-	top := frame{end: len(core.Items) - 1}
+	top := frame{end: len(shown) - 1}
 	stack := []frame{}
 	openNode := func(prefix string) {
 		iter := treestore.Append(top.iter)
@@ -124,6 +154,12 @@ func displayItems() {
 		mustf(treestore.SetValue(iter, colPath, prefix), "set path column")
 		mustf(treestore.SetValue(iter, colIconName, "folder"), "set icon-name column")
 		mustf(treestore.SetValue(iter, colIdx, invalid), "set idx column")
+		if cover := covers[prefix]; cover.start != invalid {
+			left := leftTotals[cover.end+1].sub(leftTotals[cover.start])
+			right := rightTotals[cover.end+1].sub(rightTotals[cover.start])
+			mustf(treestore.SetValue(iter, colLeftSize, left.describe()), "set left-size column")
+			mustf(treestore.SetValue(iter, colRightSize, right.describe()), "set right-size column")
+		}
 		displayAction(iter, covers[prefix].action, covers[prefix].overridden)
 		stack = append(stack, top)
 		top = frame{prefix, iter, covers[prefix].end}
@@ -134,7 +170,8 @@ func displayItems() {
 	}
 
 	// Walk the items and generate a node for each.
-	for i, item := range core.Items {
+	for i, idx := range shown {
+		item := core.Items[idx]
 		for i > top.end { // Pop stack frames for prefixes that are over.
 			closeNode()
 		}
@@ -167,14 +204,29 @@ func displayItems() {
 		// - set multiple columns in one cgo call to gtk_tree_store_set
 		// - reuse GValues for left, right, icon-name, etc., instead of allocating them anew for each node
 		openNode(path)
-		mustf(treestore.SetValue(top.iter, colIdx, i), "set idx column")
+		if cover := covers[path]; item.IsAutomatic() && cover.start == cover.end { // no other items in it
+			displayItemAction(top.iter, &item)
+		}
+		mustf(treestore.SetValue(top.iter, colIdx, idx), "set idx column")
 		mustf(treestore.SetValue(top.iter, colIconName, iconName(item)), "set icon-name column")
 		mustf(treestore.SetValue(top.iter, colLeft, describeContent(item.Left)), "set left column")
 		mustf(treestore.SetValue(top.iter, colRight, describeContent(item.Right)), "set right column")
+		mustf(treestore.SetValue(top.iter, colLeftSize, describeSize(item.Left.Properties)), "set left-size column")
+		mustf(treestore.SetValue(top.iter, colLeftModTime, describeModTime(item.Left.Properties)),
+			"set left-mtime column")
+		mustf(treestore.SetValue(top.iter, colRightSize, describeSize(item.Right.Properties)), "set right-size column")
+		mustf(treestore.SetValue(top.iter, colRightModTime, describeModTime(item.Right.Properties)),
+			"set right-mtime column")
+		mustf(treestore.SetValue(top.iter, colPerms, describePerms(item)), "set perms column")
+		mustf(treestore.SetValue(top.iter, colType, describeType(item)), "set type column")
 		if isDeleted(item) {
 			mustf(treestore.SetValue(top.iter, colNameStrike, true), "set name-strike column")
 			mustf(treestore.SetValue(top.iter, colNameColor, "#606060"), "set name-color column")
 		}
+		itemIters[idx] = top.iter
+		if item.Result.Outcome != NoOutcome {
+			displayResult(idx)
+		}
 	}
 
 	reattachModel()
@@ -183,6 +235,19 @@ func displayItems() {
 	for iter, ok := treestore.GetIterFirst(); ok; ok = treestore.IterNext(iter) {
 		maybeExpandRow(iter)
 	}
+
+	displaySummary()
+}
+
+// displayItemAction displays the action of item at iter, which must be item's own node,
+// also showing whether it has been set automatically by a rule.
+func displayItemAction(iter *gtk.TreeIter, item *Item) {
+	if !item.IsAutomatic() {
+		displayAction(iter, item.Action(), item.IsOverridden())
+		return
+	}
+	mustf(treestore.SetValue(iter, colAction, actionGlyphs[item.Action()]), "set action column")
+	mustf(treestore.SetValue(iter, colActionColor, automaticColor), "set action-color column")
 }
 
 func displayAction(iter *gtk.TreeIter, act Action, overridden bool) {
@@ -195,6 +260,33 @@ func displayAction(iter *gtk.TreeIter, act Action, overridden bool) {
 	mustf(treestore.SetValue(iter, colActionColor, color), "set action-color column")
 }
 
+// displayResults refreshes the result column for those of core.Items whose Result has changed
+// since they were last displayed.
+func displayResults() {
+	if len(displayedResults) != len(core.Items) { // not displayed yet
+		return
+	}
+	for i := range displayedResults {
+		if itemIters[i] != nil && core.Items[i].Result != displayedResults[i] { // nil if filtered out
+			displayResult(i)
+		}
+	}
+}
+
+func displayResult(i int) {
+	result := core.Items[i].Result
+	mustf(treestore.SetValue(itemIters[i], colResult, outcomeLabels[result.Outcome]), "set result column")
+	mustf(treestore.SetValue(itemIters[i], colResultColor, outcomeColors[result.Outcome]), "set result-color column")
+	displayedResults[i] = result
+}
+
+func describeResult(r Result) string {
+	if r.Reason == "" {
+		return outcomeLabels[r.Outcome]
+	}
+	return outcomeLabels[r.Outcome] + ": " + r.Reason
+}
+
 func combineAction(act1 Action, overrid1 bool, act2 Action, overrid2 bool) (act Action, overrid bool) {
 	switch act1 {
 	case NoAction, act2:
@@ -234,7 +326,7 @@ func describeContent(c Content) string {
 	case Deleted:
 		return "deleted"
 	}
-	if c.Type == Absent {
+	if c.Type == Absent || c == (Content{}) {
 		return ""
 	}
 	panic(fmt.Sprintf("impossible replica content: %+v", c))
@@ -283,9 +375,129 @@ func describeContentFull(c Content) string {
 	if c.Type == Absent {
 		return "absent"
 	}
+	if c == (Content{}) { // Unison doesn't describe the replicas of an erroneous item
+		return "unknown"
+	}
 	panic(fmt.Sprintf("impossible replica content: %+v", c))
 }
 
+// describeType returns the kind of item, or its extension (which compareTypes sorts by) if it's a file.
+func describeType(item Item) string {
+	content := item.Left
+	if content.Type == Absent {
+		content = item.Right
+	}
+	switch content.Type {
+	case File:
+		if ext, ok := IgnoredExt(item.Path); ok && ext != "" {
+			return strings.ToLower(ext)
+		}
+		return "file"
+	case Directory:
+		return "folder"
+	case Symlink:
+		return "link"
+	default:
+		return ""
+	}
+}
+
+func describeSize(p Properties) string {
+	if !p.HasSize {
+		return ""
+	}
+	return glib.FormatSize(uint64(p.Size))
+}
+
+func describeModTime(p Properties) string {
+	if p.ModTime.IsZero() {
+		return ""
+	}
+	return p.ModTime.Format("2006-01-02 15:04")
+}
+
+// describePerms returns the permissions of item, or both sides' permissions if they differ.
+func describePerms(item Item) string {
+	left, right := item.Left.Properties, item.Right.Properties
+	switch {
+	case left.HasPerms && right.HasPerms && left.Perms != right.Perms:
+		return formatPerms(left.Perms) + " ≠ " + formatPerms(right.Perms)
+	case left.HasPerms:
+		return formatPerms(left.Perms)
+	case right.HasPerms:
+		return formatPerms(right.Perms)
+	default:
+		return ""
+	}
+}
+
+// A sizeTotal accumulates the sizes of several items, of which n have a known size.
+type sizeTotal struct {
+	size int64
+	n    int
+}
+
+func (t sizeTotal) add(p Properties) sizeTotal {
+	if p.HasSize {
+		t.size += p.Size
+		t.n++
+	}
+	return t
+}
+
+func (t sizeTotal) sub(t1 sizeTotal) sizeTotal {
+	return sizeTotal{t.size - t1.size, t.n - t1.n}
+}
+
+func (t sizeTotal) describe() string {
+	return describeSize(Properties{Size: t.size, HasSize: t.n > 0})
+}
+
+func displaySummary() {
+	summaryLabel.SetMarkup(describeSummary(Summarize(core.Items), core.Left, core.Right))
+}
+
+// describeSummary returns markup for s, such as "→ 3 (1.2 kB)  ·  ← 0 (0 bytes)  ·  2 conflicts".
+func describeSummary(s Summary, left, right string) string {
+	glyph := func(act Action) string {
+		return fmt.Sprintf(`<span foreground="%s">%s</span>`, actionColors[act], actionGlyphs[act])
+	}
+	parts := []string{
+		fmt.Sprintf("%s %d (%s)", glyph(LeftToRight), s.LeftToRight, glib.FormatSize(uint64(s.BytesLeftToRight))),
+		fmt.Sprintf("%s %d (%s)", glyph(RightToLeft), s.RightToLeft, glib.FormatSize(uint64(s.BytesRightToLeft))),
+	}
+	if s.Merge > 0 {
+		parts = append(parts, fmt.Sprintf("%s %d", glyph(Merge), s.Merge))
+	}
+	if s.Skip > 0 {
+		parts = append(parts, fmt.Sprintf("%d to skip", s.Skip))
+	}
+	if s.Conflicts > 0 {
+		parts = append(parts, fmt.Sprintf(`<span foreground="%s">%s</span>`,
+			actionColors[Skip], countOf(s.Conflicts, "conflict")))
+	}
+	if s.Problems > 0 {
+		parts = append(parts, fmt.Sprintf(`<span foreground="%s">%s</span>`,
+			actionColors[Problem], countOf(s.Problems, "error")))
+	}
+	if s.DeleteLeft > 0 {
+		parts = append(parts, fmt.Sprintf("<b>deletes %s on %s</b>",
+			countOf(s.DeleteLeft, "item"), html.EscapeString(left)))
+	}
+	if s.DeleteRight > 0 {
+		parts = append(parts, fmt.Sprintf("<b>deletes %s on %s</b>",
+			countOf(s.DeleteRight, "item"), html.EscapeString(right)))
+	}
+	return strings.Join(parts, "  ·  ")
+}
+
+func countOf(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
 func isDeleted(item Item) bool {
 	left := item.Left.Status
 	right := item.Right.Status
@@ -302,6 +514,7 @@ var (
 		RightToLeft:        "←",
 		RightToLeftPartial: "←?",
 		Merge:              "←M→",
+		Problem:            "⚠",
 		Mixed:              "•••",
 	}
 	glyphActions = map[string]Action{} // filled in init below
@@ -312,9 +525,11 @@ var (
 		Skip:               "#FF9780",
 		LeftToRightPartial: "#FF9780",
 		RightToLeftPartial: "#FF9780",
+		Problem:            "#E0304E",
 		Mixed:              "#BABABA",
 	}
 	overriddenColor    = "#4BC74A"
+	automaticColor     = "#2AA198"
 	actionDescriptions = map[Action]string{ // XXX: later changed by setReplicaNames
 		Skip:               "skip",
 		LeftToRight:        "propagate from left to right",
@@ -322,8 +537,23 @@ var (
 		RightToLeft:        "propagate from right to left",
 		RightToLeftPartial: "propagate from right to left, partial",
 		Merge:              "merge the versions",
+		Problem:            "none, due to an error",
 		Mixed:              "varies between items",
 	}
+	outcomeLabels = map[Outcome]string{
+		Pending:    "pending",
+		InProgress: "in progress",
+		Done:       "done",
+		Failed:     "failed",
+		Skipped:    "skipped",
+	}
+	outcomeColors = map[Outcome]string{
+		Pending:    "#BABABA",
+		InProgress: "#60C1F8",
+		Done:       "#4BC74A",
+		Failed:     "#E0304E",
+		Skipped:    "#FF9780",
+	}
 )
 
 func init() {
@@ -333,6 +563,9 @@ func init() {
 }
 
 func iconName(item Item) string {
+	if item.Recommendation == Problem {
+		return "dialog-error"
+	}
 	content := item.Left
 	if content.Type == Absent {
 		content = item.Right
@@ -366,8 +599,15 @@ func iconName(item Item) string {
 }
 
 var (
-	squash      = false
-	currentSort sortRule
+	squash        = false
+	currentSort   sortRule
+	secondarySort sortRule       // breaks ties in currentSort
+	planOrder     map[string]int // position of each Item (by Path) in the plan as Unison listed it
+	filter        Filter
+	history       History // of changes to overrides, for undo and redo
+
+	itemIters        []*gtk.TreeIter // node displaying each of core.Items (nil if filtered out), as generated by displayItems
+	displayedResults []Result        // Result currently displayed for each of core.Items
 )
 
 type sortRule struct {
@@ -375,44 +615,215 @@ type sortRule struct {
 	order  gtk.SortType
 }
 
-func onPathColumnClicked()   { cycleSort(pathColumn) }
-func onActionColumnClicked() { cycleSort(actionColumn) }
+func onPathColumnClicked()         { cycleSort(pathColumn) }
+func onTypeColumnClicked()         { cycleSort(typeColumn) }
+func onLeftColumnClicked()         { cycleSort(leftColumn) }
+func onActionColumnClicked()       { cycleSort(actionColumn) }
+func onRightColumnClicked()        { cycleSort(rightColumn) }
+func onLeftSizeColumnClicked()     { cycleSort(leftSizeColumn) }
+func onLeftModTimeColumnClicked()  { cycleSort(leftModTimeColumn) }
+func onRightSizeColumnClicked()    { cycleSort(rightSizeColumn) }
+func onRightModTimeColumnClicked() { cycleSort(rightModTimeColumn) }
 
 func cycleSort(col *gtk.TreeViewColumn) {
-	if currentSort == (sortRule{col, gtk.SORT_ASCENDING}) {
+	switch currentSort {
+	case sortRule{col, gtk.SORT_ASCENDING}:
 		setSort(sortRule{col, gtk.SORT_DESCENDING})
-	} else {
+	case sortRule{col, gtk.SORT_DESCENDING}:
+		// The third click returns to the original order.
+		setSort(sortRule{})
+		sortItems()
+	default:
 		setSort(sortRule{col, gtk.SORT_ASCENDING})
 	}
 }
 
+// setSort makes rule the current sort rule, and the previous one (if on a different column)
+// the secondary, then sorts and displays the items. The zero rule means that items are
+// in no particular order: setSort leaves them as they are.
 func setSort(rule sortRule) {
+	switch {
+	case rule == (sortRule{}):
+		secondarySort = sortRule{}
+	case rule.column != currentSort.column && currentSort != (sortRule{}):
+		secondarySort = currentSort
+	}
+	currentSort = rule
+	if rule != (sortRule{}) {
+		sortItems()
+	}
+	DisplaySort(treeview, rule.column, rule.order)
+}
+
+// recordPlanOrder remembers the current order of core.Items as the original one,
+// to which sortItems falls back.
+func recordPlanOrder() {
+	planOrder = make(map[string]int, len(core.Items))
+	for i, item := range core.Items {
+		planOrder[item.Path] = i
+	}
+}
+
+func sortItems() {
 	// We don't use GtkTreeModelSortable and its associated facilities, because we
 	// don't just sort the nodes that are already being shown in the tree; instead,
 	// we sort the flat list of Items and *then* rearrange them into a tree, which
 	// becomes very different depending on the sort rule.
-	currentSort = rule
-	if rule != (sortRule{}) {
-		sort.SliceStable(core.Items, func(i, j int) bool {
-			// TODO: Remember the original order as produced by Unison, fall back to it on equals,
-			// and allow the user to return to that original order.
-			switch rule {
-			case sortRule{pathColumn, gtk.SORT_ASCENDING}:
-				return core.Items[i].Path < core.Items[j].Path
-			case sortRule{pathColumn, gtk.SORT_DESCENDING}:
-				return core.Items[i].Path > core.Items[j].Path
-			case sortRule{actionColumn, gtk.SORT_ASCENDING}:
-				return core.Items[i].Action() < core.Items[j].Action()
-			case sortRule{actionColumn, gtk.SORT_DESCENDING}:
-				return core.Items[i].Action() > core.Items[j].Action()
-			// XXX: When adding new sort rules, don't forget to update TestDisplayItemsSorted.
-			default:
-				panic("impossible case")
-			}
-		})
-		displayItems()
+	sort.SliceStable(core.Items, func(i, j int) bool {
+		return compareItems(core.Items[i], core.Items[j]) < 0
+	})
+	displayItems()
+}
+
+// compareItems returns a negative number if a sorts before b, a positive number if after,
+// or zero if they are not ordered relative to each other.
+func compareItems(a, b Item) int {
+	if c := compareBy(currentSort, a, b); c != 0 {
+		return c
+	}
+	if c := compareBy(secondarySort, a, b); c != 0 {
+		return c
+	}
+	return compareInts(int64(planOrder[a.Path]), int64(planOrder[b.Path]))
+}
+
+func compareBy(rule sortRule, a, b Item) int {
+	var c int
+	switch rule.column {
+	case nil:
+		return 0
+	case pathColumn:
+		c = strings.Compare(a.Path, b.Path)
+	case typeColumn:
+		c = compareTypes(a, b)
+	case leftColumn:
+		c = compareInts(int64(a.Left.Status), int64(b.Left.Status))
+	case actionColumn:
+		c = compareInts(int64(a.Action()), int64(b.Action()))
+	case rightColumn:
+		c = compareInts(int64(a.Right.Status), int64(b.Right.Status))
+	case leftSizeColumn:
+		c = compareSizes(a.Left.Properties, b.Left.Properties)
+	case leftModTimeColumn:
+		c = compareModTimes(a.Left.Properties, b.Left.Properties)
+	case rightSizeColumn:
+		c = compareSizes(a.Right.Properties, b.Right.Properties)
+	case rightModTimeColumn:
+		c = compareModTimes(a.Right.Properties, b.Right.Properties)
+	// XXX: When adding new sort rules, don't forget to update TestDisplayItemsSorted.
+	default:
+		panic("impossible case")
+	}
+	if rule.order == gtk.SORT_DESCENDING {
+		c = -c
+	}
+	return c
+}
+
+func compareInts(x, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// compareTypes orders folders before files (by extension) before links.
+func compareTypes(a, b Item) int {
+	rank := func(item Item) (int64, string) {
+		content := item.Left
+		if content.Type == Absent {
+			content = item.Right
+		}
+		switch content.Type {
+		case Directory:
+			return 0, ""
+		case File:
+			ext, _ := IgnoredExt(item.Path)
+			return 1, strings.ToLower(ext)
+		case Symlink:
+			return 2, ""
+		default:
+			return 3, ""
+		}
+	}
+	rankA, extA := rank(a)
+	rankB, extB := rank(b)
+	if c := compareInts(rankA, rankB); c != 0 {
+		return c
+	}
+	return strings.Compare(extA, extB)
+}
+
+// compareSizes orders unknown sizes before all others.
+func compareSizes(p, q Properties) int {
+	if c := compareBools(p.HasSize, q.HasSize); c != 0 {
+		return c
+	}
+	return compareInts(p.Size, q.Size)
+}
+
+// compareModTimes orders unknown times before all others.
+func compareModTimes(p, q Properties) int {
+	switch {
+	case p.ModTime.Before(q.ModTime):
+		return -1
+	case p.ModTime.After(q.ModTime):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareBools(x, y bool) int {
+	switch {
+	case x == y:
+		return 0
+	case y:
+		return -1
+	default:
+		return 1
 	}
-	DisplaySort(treeview, rule.column, rule.order)
+}
+
+var (
+	filterOnlies = map[string]Only{
+		"conflicts":  OnlyConflicts,
+		"deletions":  OnlyDeletions,
+		"overridden": OnlyOverridden,
+	}
+	filterStatuses = map[string]Status{
+		"created":  Created,
+		"modified": Modified,
+		"props":    PropsChanged,
+		"deleted":  Deleted,
+	}
+	filterTypes = map[string]Type{
+		"file":      File,
+		"directory": Directory,
+		"symlink":   Symlink,
+	}
+)
+
+func onFilterChanged() {
+	text, _ := filterEntry.GetText()
+	filter = Filter{
+		Pattern: strings.TrimSpace(text),
+		Only:    filterOnlies[filterOnlyCombo.GetActiveID()],
+		Status:  filterStatuses[filterStatusCombo.GetActiveID()],
+		Type:    filterTypes[filterTypeCombo.GetActiveID()],
+	}
+	// This also clears the selection, so that menu actions can't apply to items that are now hidden.
+	displayItems()
+	updateMenuItems()
+}
+
+func onFilterEntryStopSearch() {
+	filterEntry.SetText("")
+	treeview.GrabFocus()
 }
 
 func onTreeviewPopupMenu() {
@@ -435,18 +846,94 @@ func onTreeviewButtonPressEvent(_ *gtk.TreeView, ev *gdk.Event) bool {
 	return handleDefault
 }
 
+// onTreeviewKeyPressEvent handles shortcuts for the item menu. The keys for setting actions
+// mirror those of Unison's text interface.
+func onTreeviewKeyPressEvent(_ *gtk.TreeView, ev *gdk.Event) bool {
+	evk := gdk.EventKeyNewFromEvent(ev)
+	// Shift is needed to type some of the characters, such as >, so it doesn't matter.
+	mods := gdk.ModifierType(evk.State()) & (gdk.CONTROL_MASK | gdk.MOD1_MASK)
+	var menuItem *gtk.MenuItem
+	switch {
+	case mods == 0 && evk.KeyVal() == gdk.KEY_greater:
+		menuItem = leftToRightMenuItem
+	case mods == 0 && evk.KeyVal() == gdk.KEY_less:
+		menuItem = rightToLeftMenuItem
+	case mods == 0 && evk.KeyVal() == gdk.KEY_m:
+		menuItem = mergeMenuItem
+	case mods == 0 && evk.KeyVal() == gdk.KEY_slash:
+		menuItem = skipMenuItem
+	case mods == 0 && evk.KeyVal() == gdk.KEY_BackSpace:
+		menuItem = revertMenuItem
+	case mods == gdk.CONTROL_MASK && evk.KeyVal() == gdk.KEY_z:
+		menuItem = undoMenuItem
+	case mods == gdk.CONTROL_MASK && evk.KeyVal() == gdk.KEY_Z: // Ctrl+Shift+Z
+		menuItem = redoMenuItem
+	case mods == gdk.CONTROL_MASK && evk.KeyVal() == gdk.KEY_d:
+		menuItem = diffMenuItem
+	case mods == gdk.MOD1_MASK && evk.KeyVal() == gdk.KEY_Down:
+		gotoConflict(true)
+		return blockDefault
+	case mods == gdk.MOD1_MASK && evk.KeyVal() == gdk.KEY_Up:
+		gotoConflict(false)
+		return blockDefault
+	default:
+		return handleDefault
+	}
+	if menuItem.GetSensitive() { // kept up to date by updateMenuItems
+		menuItem.Activate()
+	}
+	return blockDefault
+}
+
+// gotoConflict moves the cursor to the next (or previous) displayed item that is a conflict.
+func gotoConflict(forward bool) {
+	cursor, _ := treeview.GetCursor()
+	var target string
+	treestore.ForEach(gtk.TreeModelForeachFunc(
+		func(_ *gtk.TreeModel, treepath *gtk.TreePath, iter *gtk.TreeIter) bool {
+			if item := itemAt(iter); item == nil || !isConflict(*item) {
+				return false // means "continue ForEach"
+			}
+			if forward {
+				if cursor == nil || treepath.Compare(cursor) > 0 {
+					target = treepath.String()
+					return true // means "break ForEach"
+				}
+				return false
+			}
+			if cursor != nil && treepath.Compare(cursor) >= 0 {
+				return true
+			}
+			target = treepath.String() // but maybe there's a closer (or, without a cursor, a later) one
+			return false
+		},
+	))
+	if target == "" {
+		return
+	}
+	treepath, err := gtk.TreePathNewFromString(target)
+	if !shouldf(err, "make treepath from %s", target) {
+		return
+	}
+	treeview.ExpandToPath(treepath)
+	treeview.SetCursor(treepath, nil, false)
+}
+
 func onTreeSelectionChanged() {
 	updateMenuItems()
 }
 
 func updateMenuItems() {
 	// What has been selected?
+	var first *Item
 	some := false
 	multiple := false
 	onlyFiles := true
 	forEachSelectedItem(func(_ *gtk.TreePath, _ *gtk.TreeIter, item *Item) bool {
 		if some {
 			multiple = true
+		} else {
+			first = item
 		}
 		some = true
 		onlyFiles = onlyFiles && item.Left.Type == File && item.Right.Type == File
@@ -458,11 +945,38 @@ func updateMenuItems() {
 	mergeMenuItem.SetSensitive(core.Sync != nil && some && onlyFiles)
 	skipMenuItem.SetSensitive(core.Sync != nil && some)
 	revertMenuItem.SetSensitive(core.Sync != nil && some)
-	diffMenuItem.SetSensitive(core.Diff != nil && some && !multiple && onlyFiles)
+	undoMenuItem.SetSensitive(core.Sync != nil && history.CanUndo())
+	redoMenuItem.SetSensitive(core.Sync != nil && history.CanRedo())
+	diffMenuItem.SetSensitive(core.Diff != nil && some && onlyFiles)
+	rescanMenuItem.SetSensitive((core.Rescan != nil || !core.Running) && some)
+	rescanAllMenuItem.SetSensitive((core.Rescan != nil || !core.Running) && fullArgs != nil)
+
+	ignorePathMenuItem.SetSensitive(core.Ignore != nil && some && !multiple)
+	ignoreNameMenuItem.SetSensitive(core.Ignore != nil && some && !multiple)
+	ignoreExtMenuItem.SetSensitive(core.Ignore != nil && some && !multiple)
+	ignoreNameMenuItem.SetLabel("Ignore everything _named…")
+	ignoreExtMenuItem.SetLabel("Ignore _extension…")
+	if some && !multiple {
+		// Any underscores in the name must not be taken for mnemonics.
+		escape := func(s string) string { return strings.ReplaceAll(s, "_", "__") }
+		ignoreNameMenuItem.SetLabel(fmt.Sprintf("Ignore everything _named “%s”",
+			escape(IgnoredName(first.Path))))
+		if ext, ok := IgnoredExt(first.Path); ok {
+			ignoreExtMenuItem.SetLabel(fmt.Sprintf("Ignore _extension “.%s”", escape(ext)))
+		} else {
+			ignoreExtMenuItem.SetSensitive(false)
+		}
+	}
+	exportMenuItem.SetSensitive(len(core.Items) > 0)
+	importMenuItem.SetSensitive(core.Sync != nil)
 
 	squashMenuItem.HandlerBlock(onSquashMenuItemToggledHandle)
 	squashMenuItem.SetActive(squash)
 	squashMenuItem.HandlerUnblock(onSquashMenuItemToggledHandle)
+
+	for _, opt := range optionalColumns {
+		opt.menuItem.SetActive(opt.column.GetVisible()) // no harm if it triggers the toggled handler
+	}
 }
 
 func onLeftToRightMenuItemActivate() { setAction(LeftToRight) }
@@ -472,40 +986,97 @@ func onSkipMenuItemActivate()        { setAction(Skip) }
 func onRevertMenuItemActivate()      { setAction(NoAction) }
 
 func setAction(act Action) {
-	// Keep track of ancestor nodes for which we'll need to refresh combined actions,
-	// as sets of gtk_tree_path_to_string sorted into groups by tree depth.
-	invalidated := []map[string]bool{}
-
+	var changes []OverrideChange
+	invalidated := ancestors{}
 	forEachSelectedItem(func(treepath *gtk.TreePath, iter *gtk.TreeIter, item *Item) bool {
-		item.Override = act
-		displayAction(iter, item.Action(), item.IsOverridden())
-		for treepath.Up() { // invalidate all ancestors
-			depth := treepath.GetDepth()
-			if depth < 1 {
-				break
-			}
-			for len(invalidated) < depth {
-				invalidated = append(invalidated, map[string]bool{})
-			}
-			if Seen(invalidated[depth-1], treepath.String()) {
-				break
-			}
+		if !item.CanSet(act) {
+			return true
 		}
+		if item.Override != act || item.Rule != nil {
+			changes = append(changes, OverrideChange{item.Path, item.Override, act, item.Rule, nil})
+		}
+		item.Override, item.Rule = act, nil // now the user's choice, even if it's the same as the rule's
+		displayItemAction(iter, item)
+		invalidated.add(treepath)
 		return true
 	})
+	history.Record(changes)
+	invalidated.refresh()
+	overridesChanged()
+}
+
+func onUndoMenuItemActivate() { applyChanges(history.Undo()) }
+func onRedoMenuItemActivate() { applyChanges(history.Redo()) }
+
+// applyChanges sets overrides as recorded in changes (by undo or redo), regardless of selection,
+// and refreshes the display, including items hidden by the filter.
+func applyChanges(changes []OverrideChange) {
+	if len(changes) == 0 {
+		return
+	}
+	index := make(map[string]int, len(core.Items))
+	for i, item := range core.Items {
+		index[item.Path] = i
+	}
+	invalidated := ancestors{}
+	for _, change := range changes {
+		i, ok := index[change.Path]
+		if !ok {
+			log.Printf("cannot find item %s to set its override", change.Path)
+			continue
+		}
+		item := &core.Items[i]
+		item.Override, item.Rule = change.New, change.NewRule
+		if iter := itemIters[i]; iter != nil { // nil if filtered out
+			displayItemAction(iter, item)
+			treepath, err := treestore.GetPath(iter)
+			if shouldf(err, "get treepath for %s", change.Path) {
+				invalidated.add(treepath)
+			}
+		}
+	}
+	invalidated.refresh()
+	overridesChanged()
+}
 
-	// Refresh combined actions on all ancestor nodes that have been invalidated,
-	// beginning with the deepest ones and moving up the tree
-	// (recomputing a node's action can affect its ancestors but not its descendants).
-	for i := len(invalidated) - 1; i >= 0; i-- {
-		for treepathS := range invalidated[i] {
+// ancestors keeps track of ancestor nodes for which we need to refresh combined actions,
+// as sets of gtk_tree_path_to_string sorted into groups by tree depth.
+type ancestors []map[string]bool
+
+// add invalidates all ancestors of treepath, which it modifies.
+func (a *ancestors) add(treepath *gtk.TreePath) {
+	for treepath.Up() {
+		depth := treepath.GetDepth()
+		if depth < 1 {
+			break
+		}
+		for len(*a) < depth {
+			*a = append(*a, map[string]bool{})
+		}
+		if Seen((*a)[depth-1], treepath.String()) {
+			break
+		}
+	}
+}
+
+// refresh refreshes combined actions on all invalidated nodes, beginning with the deepest ones
+// and moving up the tree (recomputing a node's action can affect its ancestors but not its descendants).
+func (a ancestors) refresh() {
+	for i := len(a) - 1; i >= 0; i-- {
+		for treepathS := range a[i] {
 			refreshParentAction(treepathS)
 		}
 	}
+}
+
+// overridesChanged updates the rest of the UI after the user has changed some overrides.
+func overridesChanged() {
+	displaySummary()
+	updateMenuItems()
 
 	// If the tree was sorted by action, we now have to either sort (and displayItems) again, or
 	// just indicate that it's no longer sorted, which is easier and probably more useful.
-	if currentSort.column == actionColumn {
+	if currentSort.column == actionColumn || secondarySort.column == actionColumn {
 		setSort(sortRule{})
 	}
 }
@@ -531,16 +1102,149 @@ func onDiffMenuItemActivate() {
 		update(Update{})
 		return
 	}
+	var items []*Item
 	forEachSelectedItem(func(_ *gtk.TreePath, _ *gtk.TreeIter, item *Item) bool {
-		update(core.Diff(item.Path))
+		items = append(items, item)
+		return true
+	})
+	if len(items) > 0 {
+		diffItems(items)
+	}
+}
+
+func onRescanMenuItemActivate() {
+	var paths []string
+	for li, next := Iter(treeSelection.GetSelectedRows(nil)); li != nil; li = next() {
+		iter, err := treestore.GetIter(li.Data().(*gtk.TreePath))
+		mustf(err, "get tree iter for selected row")
+		paths = append(paths, pathAt(iter))
+	}
+	paths = TopmostPaths(paths)
+	if len(paths) == 0 {
+		return
+	}
+	if paths[0] == "" { // entire replica, so there's nothing to restrict
+		paths = nil
+	}
+
+	// Items outside of paths will not be in the new plan. Forget their overrides now,
+	// so that the core doesn't report them as discarded.
+	ForgetOverridesOutside(core.Items, paths)
+
+	if fullArgs == nil {
+		fullArgs = unisonArgs
+	}
+	if paths == nil {
+		unisonArgs, fullArgs = fullArgs, nil
+	} else {
+		unisonArgs = ReplacePaths(fullArgs, paths...)
+	}
+	rescan()
+}
+
+func onRescanAllMenuItemActivate() {
+	unisonArgs, fullArgs = fullArgs, nil
+	rescan()
+}
+
+func rescan() {
+	if core.Running {
+		invokeUpdate(core.Rescan)
+	} else {
+		restartUnison(unisonArgs...)
+	}
+}
+
+func onIgnorePathMenuItemActivate() { ignore(IgnorePath) }
+func onIgnoreNameMenuItemActivate() { ignore(IgnoreName) }
+func onIgnoreExtMenuItemActivate()  { ignore(IgnoreExt) }
+
+func ignore(what Ignore) {
+	if core.Ignore == nil {
+		log.Println("cannot invoke core.Ignore because it is already nil")
+		update(Update{})
+		return
+	}
+	var itemPath string
+	forEachSelectedItem(func(_ *gtk.TreePath, _ *gtk.TreeIter, item *Item) bool {
+		itemPath = item.Path
 		return false // stop after the first item
 	})
+	update(core.Ignore(itemPath, what))
 }
 
 // TODO: This variable would not be needed if gotk3 had bindings for g_signal_handlers_block_by_func
 // or g_signal_handler_find.
 var onSquashMenuItemToggledHandle glib.SignalHandle
 
+// An optionalColumn is hidden by default, and can be shown with a check item in the Columns submenu.
+type optionalColumn struct {
+	column   *gtk.TreeViewColumn
+	menuItem *gtk.CheckMenuItem
+}
+
+var optionalColumns []optionalColumn
+
+func onExportMenuItemActivate() {
+	chooser, err := gtk.FileChooserNativeDialogNew("Export plan", window,
+		gtk.FILE_CHOOSER_ACTION_SAVE, "_Export", "_Cancel")
+	if !shouldf(err, "create file chooser") {
+		return
+	}
+	defer chooser.Destroy()
+	chooser.SetDoOverwriteConfirmation(true)
+	chooser.SetCurrentName("plan.json")
+	if chooser.Run() != int(gtk.RESPONSE_ACCEPT) {
+		return
+	}
+	name := chooser.GetFilename()
+	var buf bytes.Buffer
+	if !checkf(ExportPlan(&buf, ExportFormatFor(name), core), "export plan") {
+		return
+	}
+	checkf(os.WriteFile(name, buf.Bytes(), 0o644), "export plan to %v", name)
+}
+
+func onImportMenuItemActivate() {
+	chooser, err := gtk.FileChooserNativeDialogNew("Import decisions", window,
+		gtk.FILE_CHOOSER_ACTION_OPEN, "_Import", "_Cancel")
+	if !shouldf(err, "create file chooser") {
+		return
+	}
+	defer chooser.Destroy()
+	if chooser.Run() != int(gtk.RESPONSE_ACCEPT) {
+		return
+	}
+	name := chooser.GetFilename()
+	f, err := os.Open(name)
+	if !checkf(err, "open %v", name) {
+		return
+	}
+	defer f.Close()
+	decisions, err := ReadDecisions(f)
+	if !checkf(err, "import decisions from %v", name) {
+		return
+	}
+	changes, msgs := ResolveDecisions(core.Items, decisions)
+	messages = append(messages, Message{
+		fmt.Sprintf("Set actions for %s from %s.", countOf(len(changes), "item"), filepath.Base(name)),
+		Info,
+	})
+	messages = append(messages, msgs...)
+	updateInfobar()
+	history.Record(changes) // so that the whole import can be undone at once
+	applyChanges(changes)
+}
+
+func isOptionalColumn(column *gtk.TreeViewColumn) bool {
+	for _, opt := range optionalColumns {
+		if opt.column.Native() == column.Native() {
+			return true
+		}
+	}
+	return false
+}
+
 func onSquashMenuItemToggled() {
 	squash = squashMenuItem.GetActive()
 	// Let the user immediately see the effect on whichever nodes they were looking at.
@@ -578,11 +1282,20 @@ func treeTooltip(tip *gtk.Tooltip) bool {
 			html.EscapeString(item.Right.Props),
 			actionDescriptions[item.Action()],
 		)
+		if item.IsAutomatic() {
+			markup += fmt.Sprintf("\n<b>set by rule</b>:\t<tt>%s</tt>", html.EscapeString(item.Rule.Text))
+		}
 		if item.Action() != item.Recommendation {
 			markup += fmt.Sprintf("\n<b>Unison’s recommendation</b>: %s",
 				actionDescriptions[item.Recommendation],
 			)
 		}
+		if item.Error != "" {
+			markup += fmt.Sprintf("\n<b>error</b>:\t%s", html.EscapeString(item.Error))
+		}
+		if item.Result.Outcome != NoOutcome {
+			markup += fmt.Sprintf("\n<b>result</b>:\t%s", html.EscapeString(describeResult(item.Result)))
+		}
 		if item.Action() != actionAt(iter) {
 			markup += "\n<i>also contains other actions</i>"
 		}
@@ -621,6 +1334,12 @@ func treeTooltipAt(tip *gtk.Tooltip, x, y int) bool {
 		var markup string
 		if item := itemAt(iter); item != nil {
 			markup = actionDescriptions[item.Action()]
+			if item.IsAutomatic() {
+				markup += "\nset by rule: <tt>" + html.EscapeString(item.Rule.Text) + "</tt>"
+			}
+			if item.Error != "" {
+				markup += "\n" + html.EscapeString(item.Error)
+			}
 			if item.Action() != actionAt(iter) {
 				markup += "\n<i>also contains other actions</i>"
 			}
@@ -640,6 +1359,27 @@ func treeTooltipAt(tip *gtk.Tooltip, x, y int) bool {
 		}
 		tip.SetText(fmt.Sprintf("%s: %s %s", side, describeContentFull(content), content.Props))
 
+	case permsColumn.Native():
+		item := itemAt(iter)
+		if item == nil || describePerms(*item) == "" {
+			return false
+		}
+		perms := func(p Properties) string {
+			if !p.HasPerms {
+				return "unknown"
+			}
+			return formatPerms(p.Perms)
+		}
+		tip.SetText(fmt.Sprintf("%s: %s\n%s: %s",
+			core.Left, perms(item.Left.Properties), core.Right, perms(item.Right.Properties)))
+
+	case resultColumn.Native():
+		item := itemAt(iter)
+		if item == nil || item.Result.Outcome == NoOutcome {
+			return false
+		}
+		tip.SetText(describeResult(item.Result))
+
 	default:
 		return false
 	}
@@ -688,7 +1428,8 @@ func actionAt(iter *gtk.TreeIter) Action {
 }
 
 func isOverriddenAt(iter *gtk.TreeIter) bool {
-	return MustGetColumn(treestore, iter, colActionColor).(string) == overriddenColor
+	color := MustGetColumn(treestore, iter, colActionColor).(string)
+	return color == overriddenColor || color == automaticColor
 }
 
 // forEachSelectedItem calls f for each Item that is itself selected or contained in a selected
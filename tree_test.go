@@ -20,6 +20,7 @@ func TestDisplayItems(t *testing.T) {
 		items    []Item
 		squash   bool
 		sort     sortRule
+		filter   Filter
 		expected []interface{}
 	}{
 		{
@@ -363,24 +364,226 @@ func TestDisplayItems(t *testing.T) {
 				o, "baz/2", "←?→",
 			},
 		},
+		{
+			name: lineno(),
+			items: []Item{
+				item("foo/bar.txt"),
+				item("foo/baz.png", RightToLeft),
+				item("foo/qux.txt", RightToLeft),
+			},
+			filter: Filter{Pattern: "*.txt"},
+			expected: []interface{}{
+				// The parent's action is combined only from the items shown.
+				o, "foo", "•••",
+				o__o, "bar.txt", "→",
+				o__o, "qux.txt", "←",
+			},
+		},
+		{
+			name: lineno(),
+			items: []Item{
+				item("foo/bar.txt"),
+				item("foo/baz.png", RightToLeft),
+				item("foo/qux.txt", RightToLeft),
+			},
+			filter: Filter{Pattern: "q"},
+			squash: true,
+			expected: []interface{}{
+				o, "foo/qux.txt", "←",
+			},
+		},
+		{
+			name: lineno(),
+			items: []Item{
+				item("foo/bar.txt", Deleted, Absent, LeftToRight),
+				item("foo/baz.png", RightToLeft),
+				item("qux", RightToLeft, Skip),
+			},
+			filter: Filter{Only: OnlyDeletions},
+			expected: []interface{}{
+				o, "foo", "→",
+				o__o, "bar.txt", "→",
+			},
+		},
 	}
 
+	defer func() { filter = Filter{} }()
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
 			core.Items = c.items
 			squash = c.squash
 			currentSort = c.sort
+			filter = c.filter
 			displayItems()
 			assertTree(t, []int{colName, colAction}, c.expected...)
 		})
 	}
 }
 
+func TestDisplayResults(t *testing.T) {
+	core.Items = []Item{item("foo/bar"), item("foo/baz"), item("qux")}
+	squash = false
+	currentSort = sortRule{}
+	displayItems()
+	assertTree(t, []int{colName, colResult},
+		o, "foo", "",
+		o__o, "bar", "",
+		o__o, "baz", "",
+		o, "qux", "",
+	)
+
+	core.Items[0].Result = Result{Outcome: Done}
+	core.Items[1].Result = Result{Failed, "something went wrong"}
+	core.Items[2].Result = Result{Outcome: InProgress}
+	displayResults()
+	assertTree(t, []int{colName, colResult},
+		o, "foo", "",
+		o__o, "bar", "done",
+		o__o, "baz", "failed",
+		o, "qux", "in progress",
+	)
+
+	// Results survive rebuilding the tree, e.g. when sorting.
+	setSort(sortRule{pathColumn, gtk.SORT_DESCENDING})
+	assertTree(t, []int{colName, colResult},
+		o, "qux", "in progress",
+		o, "foo/baz", "failed",
+		o, "foo/bar", "done",
+	)
+}
+
+func TestDisplayItemsProperties(t *testing.T) {
+	core.Items = []Item{
+		item("foo/bar", "size 1000  rw-r--r--", LeftToRight, "size 10  rw-r--r--"),
+		item("foo/baz", "size 2000  rwxr-xr-x", LeftToRight, Content{Type: Absent}),
+		item("qux", "modified on 2021-02-07 at  1:50:31  size 500  rw-r--r--", LeftToRight),
+	}
+	squash = false
+	currentSort = sortRule{}
+	displayItems()
+	assertTree(t, []int{colName, colLeftSize, colRightSize},
+		o, "foo", "3.0 kB", "10 bytes",
+		o__o, "bar", "1.0 kB", "10 bytes",
+		o__o, "baz", "2.0 kB", "",
+		o, "qux", "500 bytes", "0 bytes",
+	)
+	assertTree(t, []int{colName, colLeftModTime, colRightModTime, colPerms},
+		o, "foo", "", "", "",
+		o__o, "bar", "", "", "rw-r--r--",
+		o__o, "baz", "", "", "rwxr-xr-x",
+		o, "qux", "2021-02-07 01:50", "2021-02-05 18:41", "rw-r--r-- ≠ rwx------",
+	)
+}
+
+func TestDisplayAutomatic(t *testing.T) {
+	rules, err := ParseRules("skip *.lock")
+	require.NoError(t, err)
+	core.Items = []Item{
+		item("foo/bar.lock"),
+		item("foo/baz"),
+		item("qux.lock"),
+	}
+	ApplyRules(core.Items, rules)
+	squash = false
+	currentSort = sortRule{}
+	history = History{}
+	displayItems()
+	treeview.ExpandAll()
+	assertTree(t, []int{colName, colAction, colActionColor},
+		o, "foo", "•••", overriddenColor, // combined with a rule
+		o__o, "bar.lock", "←?→", automaticColor,
+		o__o, "baz", "→", actionColors[LeftToRight],
+		o, "qux.lock", "←?→", automaticColor,
+	)
+
+	treeSelection.UnselectAll()
+	treeSelection.SelectIter(itemIters[2])
+	setAction(LeftToRight)
+	assertTree(t, []int{colName, colAction, colActionColor},
+		o, "foo", "•••", overriddenColor,
+		o__o, "bar.lock", "←?→", automaticColor,
+		o__o, "baz", "→", actionColors[LeftToRight],
+		o, "qux.lock", "→", overriddenColor,
+	)
+
+	onUndoMenuItemActivate()
+	assertTree(t, []int{colName, colAction, colActionColor},
+		o, "foo", "•••", overriddenColor,
+		o__o, "bar.lock", "←?→", automaticColor,
+		o__o, "baz", "→", actionColors[LeftToRight],
+		o, "qux.lock", "←?→", automaticColor,
+	)
+
+	// Setting by hand the same action as the rule's makes it the user's choice.
+	setAction(NoAction)
+	setAction(Skip)
+	assertTree(t, []int{colName, colAction, colActionColor},
+		o, "foo", "•••", overriddenColor,
+		o__o, "bar.lock", "←?→", automaticColor,
+		o__o, "baz", "→", actionColors[LeftToRight],
+		o, "qux.lock", "←?→", overriddenColor,
+	)
+	assert.False(t, core.Items[2].IsAutomatic())
+	assert.Nil(t, core.Items[2].Rule)
+
+	onUndoMenuItemActivate()
+	onUndoMenuItemActivate()
+	assert.True(t, core.Items[2].IsAutomatic())
+	assert.Same(t, &rules[0], core.Items[2].Rule)
+}
+
+func TestGotoConflict(t *testing.T) {
+	core.Items = []Item{
+		item("foo/bar", Skip),
+		item("foo/baz"),
+		item("qux", Skip, Modified, LeftToRight), // resolved by the user
+		item("xyzzy", Skip),
+	}
+	squash = false
+	currentSort = sortRule{}
+	displayItems()
+	require.NoError(t, ClearCursor(treeview))
+	cursorPath := func() string {
+		treepath, _ := treeview.GetCursor()
+		if treepath == nil {
+			return ""
+		}
+		iter, err := treestore.GetIter(treepath)
+		require.NoError(t, err)
+		return pathAt(iter)
+	}
+
+	gotoConflict(false)
+	assert.Equal(t, "xyzzy", cursorPath())
+	require.NoError(t, ClearCursor(treeview))
+	gotoConflict(true)
+	assert.Equal(t, "foo/bar", cursorPath())
+	gotoConflict(true)
+	assert.Equal(t, "xyzzy", cursorPath())
+	gotoConflict(true)
+	assert.Equal(t, "xyzzy", cursorPath())
+	gotoConflict(false)
+	assert.Equal(t, "foo/bar", cursorPath())
+	gotoConflict(false)
+	assert.Equal(t, "foo/bar", cursorPath())
+}
+
+func TestDescribeSummary(t *testing.T) {
+	assert.Equal(t,
+		`<span foreground="#60C1F8">→</span> 3 (1.0 kB)  ·  `+
+			`<span foreground="#B980FF">←</span> 0 (0 bytes)  ·  `+
+			`<span foreground="#FF9780">1 conflict</span>  ·  `+
+			`<b>deletes 4000 items on server &amp; co</b>`,
+		describeSummary(Summary{LeftToRight: 3, BytesLeftToRight: 1000, Conflicts: 1, DeleteRight: 4000},
+			"laptop", "server & co"),
+	)
+}
+
 func item(path string, opts ...interface{}) Item {
 	it := Item{
 		Path:           path,
-		Left:           Content{File, Modified, "modified on 2021-02-06 at 18:41:58  size 0         rwx------"},
-		Right:          Content{File, Unchanged, "modified on 2021-02-05 at 18:41:58  size 0         rwx------"},
+		Left:           content(File, Modified, "modified on 2021-02-06 at 18:41:58  size 0         rwx------"),
+		Right:          content(File, Unchanged, "modified on 2021-02-05 at 18:41:58  size 0         rwx------"),
 		Recommendation: LeftToRight,
 	}
 	side := &it.Left
@@ -395,6 +598,7 @@ func item(path string, opts ...interface{}) Item {
 			side.Status = opt
 		case string:
 			side.Props = opt
+			side.Properties = ParseProperties(opt)
 		case Action:
 			*action = opt
 			side = &it.Right
@@ -434,7 +638,7 @@ func genItems(t *rapid.T) []Item {
 		}
 		if rapid.IntRange(0, 99).Draw(t, "empty").(int) > 0 { // Path may be empty ("entire replica").
 			for ngrow := rapid.IntRange(1, 5).Draw(t, "ngrow").(int); ngrow > 0; ngrow-- {
-				segment := rapid.StringMatching(`[a-z]{1,2}`).Draw(t, "segment").(string)
+				segment := rapid.StringMatching(`[a-z]{1,2}(\.[a-c])?`).Draw(t, "segment").(string)
 				newpath = path.Join(newpath, segment)
 			}
 		}
@@ -446,6 +650,19 @@ func genItems(t *rapid.T) []Item {
 		seen[newpath] = true
 
 		items[i] = item(newpath, rapid.SampledFrom(actions).Draw(t, "action"))
+
+		// Vary the contents, so that sorting by them is meaningful. Narrow ranges make for more ties.
+		typ := rapid.SampledFrom([]Type{File, File, Directory, Symlink}).Draw(t, "type").(Type)
+		for _, side := range []*Content{&items[i].Left, &items[i].Right} {
+			side.Type = typ
+			side.Status = rapid.SampledFrom([]Status{Unchanged, Created, Modified}).Draw(t, "status").(Status)
+			side.Props = fmt.Sprintf("modified on 2021-02-0%d at %2d:00:00  size %-9d rw-r--r--",
+				rapid.IntRange(1, 3).Draw(t, "day").(int),
+				rapid.IntRange(0, 23).Draw(t, "hour").(int),
+				rapid.IntRange(0, 3).Draw(t, "size").(int),
+			)
+			side.Properties = ParseProperties(side.Props)
+		}
 	}
 	return items
 }
@@ -473,6 +690,35 @@ func TestDisplayItemsIndices(t *testing.T) {
 	})
 }
 
+// TestDisplayItemsFiltered checks the following property:
+// Nodes generated by displayItems for items (colIdx != -1) are exactly those items that pass
+// the filter, in the same order.
+func TestDisplayItemsFiltered(t *testing.T) {
+	defer func() { filter = Filter{} }()
+	rapid.Check(t, func(t *rapid.T) {
+		core.Items = rapid.Custom(genItems).Draw(t, "items").([]Item)
+		squash = rapid.Bool().Draw(t, "squash").(bool)
+		currentSort = sortRule{}
+		filter = Filter{
+			Pattern: rapid.StringMatching(`[a-z*]{0,2}`).Draw(t, "pattern").(string),
+			Only:    rapid.SampledFrom([]Only{OnlyAny, OnlyConflicts, OnlyOverridden}).Draw(t, "only").(Only),
+		}
+		displayItems()
+		var expected, actual []int
+		for i, item := range core.Items {
+			if filter.Match(item) {
+				expected = append(expected, i)
+			}
+		}
+		forEachNode(func(iter *gtk.TreeIter) {
+			if idx := MustGetColumn(treestore, iter, colIdx).(int); idx != invalid {
+				actual = append(actual, idx)
+			}
+		})
+		assert.Equal(t, expected, actual)
+	})
+}
+
 // TestDisplayItemsNamesPaths checks the following property:
 // The path of every node generated by displayItems equals a join of its name
 // and the names of its ancestors (in reverse order).
@@ -562,29 +808,56 @@ func TestDisplayItemsNoSquash(t *testing.T) {
 	})
 }
 
-// TestDisplayItemsSorted checks the following property:
-// Parent nodes are inserted by displayItems only where they respect the current sort order
-// (if viewed as applying to the entire list of nodes, top to bottom).
+// TestDisplayItemsSorted checks the following properties:
+// Nodes for items are ordered by the current sort rule, then by the secondary one,
+// then by the original order. Parent nodes are inserted by displayItems only where they respect
+// the current sort order by path or action (if viewed as applying to the entire list of nodes,
+// top to bottom).
 func TestDisplayItemsSorted(t *testing.T) {
+	sortable := []*gtk.TreeViewColumn{
+		pathColumn, typeColumn, leftColumn, actionColumn, rightColumn,
+		leftSizeColumn, leftModTimeColumn, rightSizeColumn, rightModTimeColumn,
+	}
+	orders := []gtk.SortType{gtk.SORT_ASCENDING, gtk.SORT_DESCENDING}
 	rapid.Check(t, func(t *rapid.T) {
 		core.Items = rapid.Custom(genItems).Draw(t, "items").([]Item)
 		squash = rapid.Bool().Draw(t, "squash").(bool)
-		var allSortRules = []sortRule{
-			{pathColumn, gtk.SORT_ASCENDING},
-			{pathColumn, gtk.SORT_DESCENDING},
-			{actionColumn, gtk.SORT_ASCENDING},
-			{actionColumn, gtk.SORT_DESCENDING},
+		recordPlanOrder()
+		setSort(sortRule{})
+		// Sorting by one column and then by another makes the first one secondary.
+		for n := rapid.IntRange(1, 2).Draw(t, "nrules").(int); n > 0; n-- {
+			setSort(sortRule{ // calls displayItems
+				rapid.SampledFrom(sortable).Draw(t, "column").(*gtk.TreeViewColumn),
+				rapid.SampledFrom(orders).Draw(t, "order").(gtk.SortType),
+			})
 		}
-		setSort(rapid.SampledFrom(allSortRules).Draw(t, "sortRule").(sortRule)) // calls displayItems
 
 		var last interface{}
+		var lastItem *Item
 		forEachNode(func(iter *gtk.TreeIter) {
+			if item := itemAt(iter); item != nil {
+				if lastItem != nil {
+					primary := compareBy(currentSort, *lastItem, *item)
+					secondary := compareBy(secondarySort, *lastItem, *item)
+					assert.LessOrEqual(t, primary, 0)
+					if primary == 0 {
+						assert.LessOrEqual(t, secondary, 0)
+					}
+					if primary == 0 && secondary == 0 {
+						assert.Less(t, planOrder[lastItem.Path], planOrder[item.Path])
+					}
+				}
+				lastItem = item
+			}
+
 			var cur interface{}
 			switch currentSort.column {
 			case pathColumn:
 				cur = pathAt(iter)
 			case actionColumn:
 				cur = actionAt(iter)
+			default:
+				return
 			}
 			if last != nil {
 				switch currentSort.order {
@@ -599,6 +872,29 @@ func TestDisplayItemsSorted(t *testing.T) {
 	})
 }
 
+// TestSortCycle checks the following property:
+// Clicking the same column three times returns the items to their original order.
+func TestSortCycle(t *testing.T) {
+	sortable := []*gtk.TreeViewColumn{pathColumn, typeColumn, leftSizeColumn, rightModTimeColumn}
+	rapid.Check(t, func(t *rapid.T) {
+		core.Items = rapid.Custom(genItems).Draw(t, "items").([]Item)
+		squash = rapid.Bool().Draw(t, "squash").(bool)
+		recordPlanOrder()
+		original := append([]Item(nil), core.Items...)
+		setSort(sortRule{})
+		cycleSort(rapid.SampledFrom(sortable).Draw(t, "other").(*gtk.TreeViewColumn))
+		column := rapid.SampledFrom(sortable).Draw(t, "column").(*gtk.TreeViewColumn)
+		cycleSort(column)
+		if currentSort.order == gtk.SORT_ASCENDING { // not if the other column was the same
+			cycleSort(column)
+		}
+		cycleSort(column)
+		assert.Equal(t, sortRule{}, currentSort)
+		assert.Equal(t, sortRule{}, secondarySort)
+		assert.Equal(t, original, core.Items)
+	})
+}
+
 // TestDisplayItemsMixed checks the following property:
 // If a tree node's action is mixed (•••), it has at least one child.
 func TestDisplayItemsMixed(t *testing.T) {
@@ -655,6 +951,60 @@ func TestSetActionAsIfOriginal(t *testing.T) {
 	})
 }
 
+// TestUndoRedo checks the following property:
+// After setting actions on random selections, undoing all of it restores the original overrides
+// and the tree as displayed, and then redoing all of it restores the final ones.
+func TestUndoRedo(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		core.Items = rapid.Custom(genItems).Draw(t, "items").([]Item)
+		squash = rapid.Bool().Draw(t, "squash").(bool)
+		currentSort = sortRule{}
+		history = History{}
+		snapshot := func() (overrides []Action, actions, colors []string) {
+			for _, item := range core.Items {
+				overrides = append(overrides, item.Override)
+			}
+			forEachNode(func(iter *gtk.TreeIter) {
+				actions = append(actions, MustGetColumn(treestore, iter, colAction).(string))
+				colors = append(colors, MustGetColumn(treestore, iter, colActionColor).(string))
+			})
+			return
+		}
+
+		displayItems()
+		treeview.ExpandAll() // nodes whose parents are collapsed cannot be selected
+		overrides1, actions1, colors1 := snapshot()
+		n := rapid.IntRange(1, 3).Draw(t, "n").(int)
+		for i := 0; i < n; i++ {
+			treeSelection.UnselectAll()
+			forEachNode(func(iter *gtk.TreeIter) {
+				if rapid.Bool().Draw(t, "selected").(bool) {
+					treeSelection.SelectIter(iter)
+				}
+			})
+			var allActions = []Action{NoAction, Skip, LeftToRight, RightToLeft, Merge}
+			setAction(rapid.SampledFrom(allActions).Draw(t, "action").(Action))
+		}
+		overrides2, actions2, colors2 := snapshot()
+
+		for history.CanUndo() {
+			onUndoMenuItemActivate()
+		}
+		overrides, actions, colors := snapshot()
+		assert.Equal(t, overrides1, overrides)
+		assert.Equal(t, actions1, actions)
+		assert.Equal(t, colors1, colors)
+
+		for history.CanRedo() {
+			onRedoMenuItemActivate()
+		}
+		overrides, actions, colors = snapshot()
+		assert.Equal(t, overrides2, overrides)
+		assert.Equal(t, actions2, actions)
+		assert.Equal(t, colors2, colors)
+	})
+}
+
 func forEachNode(f func(*gtk.TreeIter)) {
 	treestore.ForEach(gtk.TreeModelForeachFunc(
 		func(_ *gtk.TreeModel, _ *gtk.TreePath, iter *gtk.TreeIter) bool {
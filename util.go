@@ -109,6 +109,22 @@ func PathIsAncestor(p1, p2 string) bool {
 	return strings.HasPrefix(p2, p1+"/") || (p2 != "" && p1 == "")
 }
 
+// TopmostPaths returns those of paths that are not equal to or contained in any other of paths
+// (preceding it, in case of equality). It does not modify paths.
+func TopmostPaths(paths []string) []string {
+	var result []string
+pathsLoop:
+	for i, p := range paths {
+		for j, q := range paths {
+			if PathIsAncestor(q, p) || (q == p && j < i) {
+				continue pathsLoop
+			}
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
 // DeleteEnv returns vars ("key=value" strings) without the given keys. It does not modify vars.
 func DeleteEnv(vars []string, keys ...string) []string {
 	result := vars
@@ -133,6 +149,25 @@ varsLoop:
 	return result
 }
 
+// ReplacePaths returns args (command-line arguments for Unison) with any -path options removed
+// and replaced with the given paths. It does not modify args.
+func ReplacePaths(args []string, paths ...string) []string {
+	result := make([]string, 0, len(args)+2*len(paths))
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-path":
+			i++ // skip the option's value, too
+		case strings.HasPrefix(args[i], "-path="):
+		default:
+			result = append(result, args[i])
+		}
+	}
+	for _, path := range paths {
+		result = append(result, "-path", path)
+	}
+	return result
+}
+
 func mustGetObject(b *gtk.Builder, name string) glib.IObject {
 	obj, err := b.GetObject(name)
 	mustf(err, "GetObject(%#v)", name)
@@ -165,7 +200,9 @@ const (
 	blockDefault  = true
 )
 
-func MustGetColumn(store *gtk.TreeStore, iter *gtk.TreeIter, column int) interface{} {
+func MustGetColumn(store interface {
+	GetValue(*gtk.TreeIter, int) (*glib.Value, error)
+}, iter *gtk.TreeIter, column int) interface{} {
 	gv, err := store.GetValue(iter, column)
 	mustf(err, "get value from column %v", column)
 	v, err := gv.GoValue()
@@ -88,3 +88,16 @@ func ExampleDeleteEnv() {
 	fmt.Println(vars)
 	// Output: [USER=joe PATH=/bin]
 }
+
+func ExampleReplacePaths() {
+	args := []string{"default", "-path", "Documents", "-times", "-path=Music"}
+	args = ReplacePaths(args, "Documents/todo.txt", "Pictures/cat.jpg")
+	fmt.Printf("%q\n", args)
+	// Output: ["default" "-times" "-path" "Documents/todo.txt" "-path" "Pictures/cat.jpg"]
+}
+
+func ExampleTopmostPaths() {
+	paths := TopmostPaths([]string{"Documents/work", "Music", "Documents", "Music", "Musicals/cats"})
+	fmt.Printf("%q\n", paths)
+	// Output: ["Music" "Documents" "Musicals/cats"]
+}